@@ -7,7 +7,9 @@ import (
 	"io/ioutil"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/codegangsta/cli"
 	"github.com/olivere/elastic"
@@ -31,6 +33,14 @@ var clusterCommand = cli.Command{
 		clusterListCommand,
 		// cluster stats
 		clusterStatsCommand,
+		// cluster reroute
+		clusterRerouteCommand,
+		// cluster rebalance
+		clusterRebalanceCommand,
+		// cluster explain
+		clusterExplainCommand,
+		// cluster rolling-restart
+		clusterRollingRestartCommand,
 	},
 }
 
@@ -72,19 +82,104 @@ func clusterStateCmd(context *cli.Context) error {
 
 // health
 var clusterHealthCommand = cli.Command{
-	Name:        "health",
-	Aliases:     []string{"h"},
-	Usage:       "get health status from elastic cluster.",
-	Description: `Display the health status of elastic cluster.`,
+	Name:    "health",
+	Aliases: []string{"h"},
+	Usage:   "get health status from elastic cluster.",
+	Description: `Display the health status of elastic cluster.
+   With the --wait-for-* flags, wait until the conditions are met or timeout, and exit with
+   nagios-style codes: 0 (OK), 1 (WARNING, conditions not met), 2 (CRITICAL, red or unreachable).
+   With --nagios only, check the status once: green is OK, yellow is WARNING and red is CRITICAL.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Value: "json",
+			Usage: "set the format of output('json' (default), or 'text' in one line).",
+		},
+		cli.BoolFlag{
+			Name:  "nagios",
+			Usage: "exit with the nagios-style codes of the status, implied by the --wait-for-* flags.",
+		},
+		cli.StringFlag{
+			Name:  "wait-for-status",
+			Value: "",
+			Usage: "wait until the status of cluster is 'green' or 'yellow' (or better).",
+		},
+		cli.BoolFlag{
+			Name:  "wait-for-no-relocating",
+			Usage: "wait until there is no relocating shards.",
+		},
+		cli.StringFlag{
+			Name:  "wait-for-nodes",
+			Value: "",
+			Usage: "wait until the number of nodes is N, also support '>=N', '<=N', '>N', '<N'.",
+		},
+		cli.DurationFlag{
+			Name:  "timeout, t",
+			Value: 30 * time.Second,
+			Usage: "set the max time to wait for the conditions, 0 to check once.",
+		},
+		cli.DurationFlag{
+			Name:  "interval, i",
+			Value: 5 * time.Second,
+			Usage: "set the interval to check the health of cluster.",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		return clusterHealthCmd(context)
 	},
 }
 
+// nagios-style exit codes of cluster health
+const (
+	healthOK       = 0
+	healthWarning  = 1
+	healthCritical = 2
+)
+
+var healthLabels = map[int]string{
+	healthOK:       "OK",
+	healthWarning:  "WARNING",
+	healthCritical: "CRITICAL",
+}
+
+// healthStatusLevel is the order of cluster health status.
+var healthStatusLevel = map[string]int{
+	"red":    0,
+	"yellow": 1,
+	"green":  2,
+}
+
 func clusterHealthCmd(context *cli.Context) error {
+	format := context.String("format")
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	waitStatus := context.String("wait-for-status")
+	if _, ok := healthStatusLevel[waitStatus]; waitStatus != "" && (!ok || waitStatus == "red") {
+		return fmt.Errorf("invalid wait-for-status %q, must be one of (green, yellow)", waitStatus)
+	}
+
+	waitNodes := context.String("wait-for-nodes")
+	if waitNodes != "" {
+		if _, _, err := parseNodesCondition(waitNodes); err != nil {
+			return err
+		}
+	}
+
+	timeout := context.Duration("timeout")
+	nagios := context.Bool("nagios") || waitStatus != "" || waitNodes != "" || context.Bool("wait-for-no-relocating")
+	if nagios && waitStatus == "" && waitNodes == "" && !context.Bool("wait-for-no-relocating") {
+		// check the status once, yellow is WARNING and red is CRITICAL.
+		waitStatus, timeout = "green", 0
+	}
+
 	// Create a client and connect to addr.
 	client, err := NewElasticClient(context)
 	if err != nil {
+		if nagios {
+			return cli.NewExitError(fmt.Sprintf("%s - %s", healthLabels[healthCritical], err), healthCritical)
+		}
 		return err
 	}
 	defer client.Stop()
@@ -92,20 +187,129 @@ func clusterHealthCmd(context *cli.Context) error {
 	// Starting with elastic.v5, you must pass a context to execute each service
 	ctx := ctx.Background()
 
-	res, err := client.ClusterHealth().Do(ctx)
-	if err != nil {
-		return err
+	var res *elastic.ClusterHealthResponse
+	var unmet []string
+	deadline := time.Now().Add(timeout)
+	for {
+		res, err = client.ClusterHealth().Do(ctx)
+		if err != nil && !nagios {
+			return err
+		}
+		if err == nil {
+			unmet = checkClusterHealth(res, waitStatus, waitNodes, context.Bool("wait-for-no-relocating"))
+			if len(unmet) == 0 {
+				break
+			}
+		}
+
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(context.Duration("interval"))
 	}
 
-	jsonStr, err := json.Marshal(res)
 	if err != nil {
-		return err
+		return cli.NewExitError(fmt.Sprintf("%s - %s", healthLabels[healthCritical], err), healthCritical)
+	}
+
+	// the red cluster is CRITICAL even if the conditions are met.
+	code := healthOK
+	if res.Status == "red" {
+		code = healthCritical
+	} else if len(unmet) > 0 {
+		code = healthWarning
+	}
+
+	switch format {
+	case "text":
+		line := formatHealthLine(res)
+		if nagios {
+			line = healthLabels[code] + " - " + line
+			if len(unmet) > 0 {
+				line += " (" + strings.Join(unmet, ", ") + ")"
+			}
+		}
+		fmt.Println(line)
+	case "json":
+		jsonStr, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		fmt.Println(jsonPrettyPrint(string(jsonStr)))
 	}
-	fmt.Println(jsonPrettyPrint(string(jsonStr)))
 
+	if nagios && code != healthOK {
+		return cli.NewExitError("", code)
+	}
 	return nil
 }
 
+// parseNodesCondition parse the wait-for-nodes condition, ex: 3, >=3, <3.
+func parseNodesCondition(cond string) (op string, num int, err error) {
+	cond = strings.TrimSpace(cond)
+	for _, prefix := range []string{">=", "<=", ">", "<"} {
+		if strings.HasPrefix(cond, prefix) {
+			op = prefix
+			break
+		}
+	}
+
+	num, err = strconv.Atoi(strings.TrimPrefix(cond, op))
+	if err != nil || num < 0 {
+		return "", 0, fmt.Errorf("invalid wait-for-nodes %q", cond)
+	}
+
+	return op, num, nil
+}
+
+// checkClusterHealth return the conditions not met by the cluster health.
+func checkClusterHealth(res *elastic.ClusterHealthResponse, waitStatus, waitNodes string, noRelocating bool) (unmet []string) {
+	if waitStatus != "" && healthStatusLevel[res.Status] < healthStatusLevel[waitStatus] {
+		unmet = append(unmet, fmt.Sprintf("status %s is not %s", res.Status, waitStatus))
+	}
+
+	if noRelocating && res.RelocatingShards > 0 {
+		unmet = append(unmet, fmt.Sprintf("%d shards relocating", res.RelocatingShards))
+	}
+
+	if waitNodes != "" {
+		op, num, _ := parseNodesCondition(waitNodes)
+		var ok bool
+		switch op {
+		case ">=":
+			ok = res.NumberOfNodes >= num
+		case "<=":
+			ok = res.NumberOfNodes <= num
+		case ">":
+			ok = res.NumberOfNodes > num
+		case "<":
+			ok = res.NumberOfNodes < num
+		default:
+			ok = res.NumberOfNodes == num
+		}
+		if !ok {
+			unmet = append(unmet, fmt.Sprintf("nodes %d is not %s", res.NumberOfNodes, waitNodes))
+		}
+	}
+
+	return unmet
+}
+
+// formatHealthLine format the cluster health in one line.
+func formatHealthLine(res *elastic.ClusterHealthResponse) string {
+	return fmt.Sprintf("cluster %s is %s: nodes %d, data nodes %d, shards %d (%.1f%% active), relocating %d, initializing %d, unassigned %d, pending tasks %d",
+		res.ClusterName,
+		res.Status,
+		res.NumberOfNodes,
+		res.NumberOfDataNodes,
+		res.ActiveShards,
+		res.ActiveShardsPercentAsNumber,
+		res.RelocatingShards,
+		res.InitializingShards,
+		res.UnassignedShards,
+		res.NumberOfPendingTasks)
+}
+
 // master
 var clusterMasterCommand = cli.Command{
 	Name:        "master",
@@ -0,0 +1,254 @@
+package main
+
+import (
+	ctx "context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+// allocationDeciderReasons is the short reason of the allocation deciders which say no.
+var allocationDeciderReasons = map[string]string{
+	"disk_threshold":               "disk watermark exceeded",
+	"same_shard":                   "a copy of the shard is already on the node",
+	"max_retry":                    "allocation failed too many times, retry with reroute --retry-failed",
+	"filter":                       "excluded by the allocation filter",
+	"awareness":                    "too many copies of the shard in the awareness zone",
+	"throttling":                   "too many recoveries in progress",
+	"enable":                       "allocation disabled by cluster.routing.allocation.enable",
+	"shards_limit":                 "total_shards_per_node limit reached",
+	"node_version":                 "the node version is older than the primary",
+	"replica_after_primary_active": "the primary shard is not active",
+	"snapshot_in_progress":         "the snapshot of the shard is in progress",
+	"restore_in_progress":          "the restore of the shard is in progress",
+	"rebalance_only_when_active":   "rebalance only when all shards are active",
+}
+
+// explain
+var clusterExplainCommand = cli.Command{
+	Name:      "explain",
+	Aliases:   []string{"e"},
+	Usage:     "Explain why the shards of elastic cluster are unassigned.",
+	ArgsUsage: `[-i index]`,
+	Description: `List every unassigned shard and call the allocation explain API for it,
+   then summarize the reasons of the deciders which say no in a grouped table.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "index, i",
+			Value: "",
+			Usage: "only explain the unassigned shards of the index, support pattern: logstash-*.",
+		},
+		cli.IntFlag{
+			Name:  "limit, l",
+			Value: 0,
+			Usage: "explain at most N unassigned shards, 0 for all.",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "set the format of output('text' (default), or 'json').",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return clusterExplainCmd(context)
+	},
+}
+
+// allocationReason group the unassigned shards by the decider which say no.
+type allocationReason struct {
+	decider     string
+	explanation string
+	shards      map[string]struct{}
+	nodes       map[string]struct{}
+}
+
+// allocationReasons sort the reasons by the number of shards.
+type allocationReasons []*allocationReason
+
+func (r allocationReasons) Len() int      { return len(r) }
+func (r allocationReasons) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r allocationReasons) Less(i, j int) bool {
+	if len(r[i].shards) != len(r[j].shards) {
+		return len(r[i].shards) > len(r[j].shards)
+	}
+	return r[i].decider < r[j].decider
+}
+
+func clusterExplainCmd(context *cli.Context) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	service := client.CatShardsService()
+	if index := context.String("index"); index != "" {
+		service = service.Index(index)
+	}
+	res, err := service.Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	explains := []*elastic.ClusterAllocationExplainResponse{}
+	// the unassigned replicas of a shard have the same explanation.
+	explained := map[string]struct{}{}
+	total := 0
+	for _, shardInfo := range res.Shards {
+		if shardInfo.State != "UNASSIGNED" {
+			continue
+		}
+		key := shardInfo.Index + "/" + shardInfo.Shard + "/" + shardInfo.Prirep
+		if _, ok := explained[key]; ok {
+			continue
+		}
+		explained[key] = struct{}{}
+		total++
+		if limit := context.Int("limit"); limit > 0 && len(explains) >= limit {
+			continue
+		}
+
+		shard, err := strconv.Atoi(shardInfo.Shard)
+		if err != nil {
+			return fmt.Errorf("invalid shard %s of index %s", shardInfo.Shard, shardInfo.Index)
+		}
+		explain, err := client.ClusterAllocationExplain().
+			Index(shardInfo.Index).
+			Shard(shard).
+			Primary(shardInfo.Prirep == "p").
+			Do(ctx)
+		if err != nil {
+			logrus.Warnf("explain shard %s[%d] failed: %s", shardInfo.Index, shard, err)
+			continue
+		}
+		explains = append(explains, explain)
+	}
+
+	switch context.String("format") {
+	case "text":
+		if total == 0 {
+			fmt.Println(sgrBoldBlue("[OK] there is no unassigned shard."))
+			return nil
+		}
+		printUnassignedShards(explains)
+		fmt.Println()
+		printAllocationReasons(groupAllocationReasons(explains))
+		if len(explains) < total {
+			fmt.Printf("\nexplained %d of %d unassigned shards.\n", len(explains), total)
+		}
+	case "json":
+		jsonStr, err := json.Marshal(explains)
+		if err != nil {
+			return err
+		}
+		fmt.Println(jsonPrettyPrint(string(jsonStr)))
+	default:
+		return fmt.Errorf("unknown format %s", context.String("format"))
+	}
+	return nil
+}
+
+// groupAllocationReasons group the shards by the deciders which say no on the nodes,
+// the shard can't be allocated to any node (ex: no valid shard copy) is grouped by can_allocate.
+func groupAllocationReasons(explains []*elastic.ClusterAllocationExplainResponse) []*allocationReason {
+	reasons := map[string]*allocationReason{}
+	group := func(decider, explanation, shard, node string) {
+		reason, ok := reasons[decider]
+		if !ok {
+			reason = &allocationReason{
+				decider:     decider,
+				explanation: explanation,
+				shards:      map[string]struct{}{},
+				nodes:       map[string]struct{}{},
+			}
+			if short, ok := allocationDeciderReasons[decider]; ok {
+				reason.explanation = short
+			}
+			reasons[decider] = reason
+		}
+		reason.shards[shard] = struct{}{}
+		if node != "" {
+			reason.nodes[node] = struct{}{}
+		}
+	}
+
+	for _, explain := range explains {
+		shard := fmt.Sprintf("%s[%d][%s]", explain.Index, explain.Shard, prirepString(explain.Primary))
+		found := false
+		for _, node := range explain.NodeAllocationDecisions {
+			for _, decider := range node.Deciders {
+				if decider.Decision == "YES" {
+					continue
+				}
+				group(decider.Decider, decider.Explanation, shard, node.NodeName)
+				found = true
+			}
+		}
+		if !found {
+			group(explain.CanAllocate, explain.AllocateExplanation, shard, "")
+		}
+	}
+
+	var list []*allocationReason
+	for _, reason := range reasons {
+		list = append(list, reason)
+	}
+	sort.Sort(allocationReasons(list))
+	return list
+}
+
+func prirepString(primary bool) string {
+	if primary {
+		return "p"
+	}
+	return "r"
+}
+
+// index shard prirep reason at can_allocate explanation
+func printUnassignedShards(explains []*elastic.ClusterAllocationExplainResponse) {
+	display := NewTableDisplay()
+	display.AddRow([]string{"index", "shard", "prirep", "reason", "at", "can_allocate", "explanation"})
+	for _, explain := range explains {
+		reason, at := "-", "-"
+		if explain.UnassignedInfo != nil {
+			reason, at = explain.UnassignedInfo.Reason, explain.UnassignedInfo.At
+		}
+		display.AddRow([]string{
+			explain.Index,
+			strconv.Itoa(explain.Shard),
+			prirepString(explain.Primary),
+			reason,
+			at,
+			explain.CanAllocate,
+			explain.AllocateExplanation})
+	}
+	display.Flush()
+}
+
+// shards decider nodes reason
+// ex: 12 shards: disk watermark exceeded on 3 nodes.
+func printAllocationReasons(reasons []*allocationReason) {
+	display := NewTableDisplay()
+	display.AddRow([]string{"shards", "decider", "nodes", "reason"})
+	for _, reason := range reasons {
+		nodes := "-"
+		if len(reason.nodes) > 0 {
+			nodes = strconv.Itoa(len(reason.nodes))
+		}
+		display.AddRow([]string{
+			strconv.Itoa(len(reason.shards)),
+			reason.decider,
+			nodes,
+			reason.explanation})
+	}
+	display.Flush()
+}
@@ -0,0 +1,581 @@
+package main
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+const awarenessAttributesStr = "cluster.routing.allocation.awareness.attributes"
+
+// rebalance
+var clusterRebalanceCommand = cli.Command{
+	Name:    "rebalance",
+	Aliases: []string{"rb"},
+	Usage:   "Balance the disk usage of data nodes by moving shards.",
+	Description: `Compute the shard moves which even out the disk percent of data nodes, the moves respect
+   the same shard and the awareness attributes, and never move a shard to the excluded nodes.
+   Print the plan by default, with --apply execute the moves in waves with the reroute API,
+   and wait for the relocation of each wave done.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "plan",
+			Usage: "only print the plan of shard moves (default), must not provide with --apply.",
+		},
+		cli.BoolFlag{
+			Name:  "apply",
+			Usage: "execute the plan of shard moves.",
+		},
+		cli.Float64Flag{
+			Name:  "threshold, t",
+			Value: 5,
+			Usage: "stop balancing when the difference of disk percent between nodes is under the threshold.",
+		},
+		cli.IntFlag{
+			Name:  "max-moves, m",
+			Value: 20,
+			Usage: "set the max number of shard moves in the plan.",
+		},
+		cli.IntFlag{
+			Name:  "wave-size, w",
+			Value: 2,
+			Usage: "set the number of shards moved at the same time.",
+		},
+		cli.StringFlag{
+			Name:  "awareness",
+			Value: "",
+			Usage: "set the awareness attributes (rack_id,zone), default from the cluster settings.",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Value: time.Hour,
+			Usage: "set the max time to wait for the relocation of each wave.",
+		},
+		cli.DurationFlag{
+			Name:  "interval, i",
+			Value: 10 * time.Second,
+			Usage: "set the interval to check the relocation.",
+		},
+		cli.BoolFlag{
+			Name:  "yes, y",
+			Usage: "Answer rebalance conform.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return clusterRebalanceCmd(context)
+	},
+}
+
+// balanceShard is a started shard copy which can be moved.
+type balanceShard struct {
+	index  string
+	shard  string
+	prirep string
+	size   int64
+	moved  bool
+}
+
+// balanceNode is a data node with its disk usage and shards.
+type balanceNode struct {
+	name     string
+	ip       string
+	host     string
+	used     int64
+	total    int64
+	attrs    map[string]string
+	excluded bool
+	shards   []*balanceShard
+	// copies contains the index/shard of all the copies on the node, include the non-started.
+	copies map[string]struct{}
+}
+
+func (n *balanceNode) percent() float64 {
+	return float64(n.used) * 100 / float64(n.total)
+}
+
+func (n *balanceNode) hasCopy(shard *balanceShard) bool {
+	_, ok := n.copies[shard.index+"/"+shard.shard]
+	return ok
+}
+
+// balanceNodes sort the nodes by disk percent from the highest.
+type balanceNodes []*balanceNode
+
+func (n balanceNodes) Len() int      { return len(n) }
+func (n balanceNodes) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
+func (n balanceNodes) Less(i, j int) bool {
+	if n[i].percent() != n[j].percent() {
+		return n[i].percent() > n[j].percent()
+	}
+	return n[i].name < n[j].name
+}
+
+// balanceShards sort the shards by size from the largest.
+type balanceShards []*balanceShard
+
+func (s balanceShards) Len() int           { return len(s) }
+func (s balanceShards) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s balanceShards) Less(i, j int) bool { return s[i].size > s[j].size }
+
+// balanceMove is a shard move of the plan, with the disk percent of nodes after the move.
+type balanceMove struct {
+	shard       *balanceShard
+	from        *balanceNode
+	to          *balanceNode
+	fromPercent float64
+	toPercent   float64
+}
+
+func clusterRebalanceCmd(context *cli.Context) error {
+	if context.Bool("plan") && context.Bool("apply") {
+		return errors.New("cluster rebalance must not provide both --plan and --apply")
+	}
+	if context.Int("wave-size") <= 0 {
+		return fmt.Errorf("invalid wave size %d", context.Int("wave-size"))
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	nodes, awareness, err := getBalanceNodes(client, ctx, context.String("awareness"))
+	if err != nil {
+		return err
+	}
+	if len(nodes) < 2 {
+		return fmt.Errorf("rebalance needs at least 2 data nodes, got %d", len(nodes))
+	}
+
+	before := map[string]float64{}
+	for _, node := range nodes {
+		before[node.name] = node.percent()
+	}
+	moves := planRebalance(nodes, awareness, context.Float64("threshold"), context.Int("max-moves"))
+
+	printBalanceNodes(nodes, before)
+	fmt.Println()
+	if len(moves) == 0 {
+		fmt.Println(sgrBoldBlue("[OK] no shard to move, the disk usage is balanced."))
+		return nil
+	}
+	printBalanceMoves(moves)
+	if context.Bool("plan") || !context.Bool("apply") {
+		return nil
+	}
+
+	fmt.Println(sgrBoldBlue("[Attention] Move above shards? type (yes) to conform rebalance."))
+	if !context.Bool("yes") {
+		YesOrDie(fmt.Sprintf("%d shard moves", len(moves)))
+	}
+
+	waveSize := context.Int("wave-size")
+	waves := (len(moves) + waveSize - 1) / waveSize
+	moved := 0
+	for i := 0; i < waves; i++ {
+		end := (i + 1) * waveSize
+		if end > len(moves) {
+			end = len(moves)
+		}
+		step := fmt.Sprintf("[%d/%d]", i+1, waves)
+		n, err := applyBalanceMoves(client, ctx, moves[i*waveSize:end], step,
+			context.Duration("timeout"), context.Duration("interval"))
+		moved += n
+		if err != nil {
+			return fmt.Errorf("%s: %s", step, err)
+		}
+	}
+
+	if moved < len(moves) {
+		fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] %d of %d shards moved, %d moves rejected.",
+			moved, len(moves), len(moves)-moved)))
+		return nil
+	}
+	fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] all %d shards moved.", len(moves))))
+	return nil
+}
+
+// getBalanceNodes get the disk usage, shards and attributes of data nodes,
+// and the awareness attributes of cluster if not provided.
+func getBalanceNodes(client *elastic.Client, ctx ctx.Context, awarenessStr string) ([]*balanceNode, []string, error) {
+	allocRes, err := client.CatAllocService().Bytes("b").Do(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var nodes []*balanceNode
+	nodeMap := map[string]*balanceNode{}
+	for _, alloc := range allocRes.Allocs {
+		used, _ := strconv.ParseInt(alloc.Used, 10, 64)
+		total, _ := strconv.ParseInt(alloc.Total, 10, 64)
+		if alloc.Node == "UNASSIGNED" || total <= 0 {
+			continue
+		}
+		node := &balanceNode{
+			name:   alloc.Node,
+			ip:     alloc.Ip,
+			host:   alloc.Host,
+			used:   used,
+			total:  total,
+			attrs:  map[string]string{},
+			copies: map[string]struct{}{},
+		}
+		nodes = append(nodes, node)
+		nodeMap[node.name] = node
+	}
+
+	shardsRes, err := client.CatShardsService().Bytes("b").Do(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, shardInfo := range shardsRes.Shards {
+		// the node of relocating shard is "node1 -> ip id node2"
+		fields := strings.Fields(shardInfo.Node)
+		if len(fields) == 0 {
+			continue
+		}
+		node, ok := nodeMap[fields[0]]
+		if !ok {
+			continue
+		}
+		node.copies[shardInfo.Index+"/"+shardInfo.Shard] = struct{}{}
+		if target, ok := nodeMap[fields[len(fields)-1]]; ok && len(fields) > 1 {
+			target.copies[shardInfo.Index+"/"+shardInfo.Shard] = struct{}{}
+		}
+		if shardInfo.State != "STARTED" {
+			continue
+		}
+		size, _ := strconv.ParseInt(shardInfo.Store, 10, 64)
+		node.shards = append(node.shards, &balanceShard{
+			index:  shardInfo.Index,
+			shard:  shardInfo.Shard,
+			prirep: shardInfo.Prirep,
+			size:   size,
+		})
+	}
+
+	attrsRes, err := client.CatNodeAttrsService().Do(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, attr := range attrsRes.NodeAttrs {
+		if node, ok := nodeMap[attr.Node]; ok {
+			node.attrs[attr.Attr] = attr.Value
+		}
+	}
+
+	settings, err := client.ClusterGetSettings().FlatSettings(true).Do(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	filters := getAllocationFilters(settings)
+	for _, node := range nodes {
+		node.excluded = !node.allowedByFilters(filters)
+	}
+
+	if awarenessStr == "" {
+		awarenessStr = getSettingString(settings.Transient, awarenessAttributesStr)
+	}
+	if awarenessStr == "" {
+		awarenessStr = getSettingString(settings.Persistent, awarenessAttributesStr)
+	}
+	var awareness []string
+	if awarenessStr != "" {
+		awareness = DeDuplicate(strings.Split(awarenessStr, ","))
+	}
+	return nodes, awareness, nil
+}
+
+// getAllocationFilters get the effective cluster allocation filters of require, include and exclude,
+// ex: {"exclude": {"_name": ["es-old-*"]}}, the transient settings override the persistent settings.
+func getAllocationFilters(settings *elastic.ClusterGetSettingsResponse) map[string]map[string][]string {
+	filters := map[string]map[string][]string{}
+	for _, scope := range []map[string]interface{}{settings.Persistent, settings.Transient} {
+		for key := range scope {
+			for _, op := range []string{"require", "include", "exclude"} {
+				prefix := "cluster.routing.allocation." + op + "."
+				if !strings.HasPrefix(key, prefix) {
+					continue
+				}
+				if filters[op] == nil {
+					filters[op] = map[string][]string{}
+				}
+				attr := strings.TrimPrefix(key, prefix)
+				if values := getExcludeFromSettings(scope, key); len(values) > 0 {
+					filters[op][attr] = values
+				} else {
+					delete(filters[op], attr)
+				}
+			}
+		}
+	}
+	return filters
+}
+
+// matchFilter is true if any value of the filter attr matches the node, the value may contain wildcards.
+// The filter by _id is not matched, as the id of node is unknown.
+func (n *balanceNode) matchFilter(attr string, values []string) bool {
+	var nodeValues []string
+	switch attr {
+	case "_name":
+		nodeValues = []string{n.name}
+	case "_ip", "_host_ip", "_publish_ip":
+		nodeValues = []string{n.ip}
+	case "_host":
+		nodeValues = []string{n.host, n.ip}
+	default:
+		if value, ok := n.attrs[attr]; ok {
+			nodeValues = []string{value}
+		}
+	}
+
+	for _, value := range values {
+		for _, nodeValue := range nodeValues {
+			if matchSimplePattern(value, nodeValue) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowedByFilters is true if the shards can be allocated to the node by the cluster allocation filters:
+// the node must match all the require filters, any of the include filters, and none of the exclude filters.
+func (n *balanceNode) allowedByFilters(filters map[string]map[string][]string) bool {
+	for attr, values := range filters["require"] {
+		if !n.matchFilter(attr, values) {
+			return false
+		}
+	}
+	if include := filters["include"]; len(include) > 0 {
+		included := false
+		for attr, values := range include {
+			if n.matchFilter(attr, values) {
+				included = true
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for attr, values := range filters["exclude"] {
+		if n.matchFilter(attr, values) {
+			return false
+		}
+	}
+	return true
+}
+
+// planRebalance move the shards from the node with highest disk percent to the lowest,
+// until the difference is under the threshold or no shard can be moved.
+func planRebalance(nodes []*balanceNode, awareness []string, threshold float64, maxMoves int) []*balanceMove {
+	var moves []*balanceMove
+	for len(moves) < maxMoves {
+		sort.Sort(balanceNodes(nodes))
+		if nodes[0].percent()-nodes[len(nodes)-1].percent() <= threshold {
+			break
+		}
+
+		move := findBalanceMove(nodes, awareness)
+		if move == nil {
+			break
+		}
+
+		move.shard.moved = true
+		move.from.used -= move.shard.size
+		move.to.used += move.shard.size
+		for i, shard := range move.from.shards {
+			if shard == move.shard {
+				move.from.shards = append(move.from.shards[:i], move.from.shards[i+1:]...)
+				break
+			}
+		}
+		move.to.shards = append(move.to.shards, move.shard)
+		move.to.copies[move.shard.index+"/"+move.shard.shard] = struct{}{}
+		move.fromPercent, move.toPercent = move.from.percent(), move.to.percent()
+		moves = append(moves, move)
+	}
+	return moves
+}
+
+// findBalanceMove find the largest shard of the fullest node which can be moved to the emptiest node,
+// and the difference of disk percent between the two nodes is reduced after the move.
+// The nodes are sorted by disk percent.
+func findBalanceMove(nodes []*balanceNode, awareness []string) *balanceMove {
+	for _, from := range nodes {
+		shards := append([]*balanceShard{}, from.shards...)
+		sort.Sort(balanceShards(shards))
+
+		for i := len(nodes) - 1; i >= 0; i-- {
+			to := nodes[i]
+			if to.percent() >= from.percent() {
+				break
+			}
+			if to.excluded {
+				continue
+			}
+
+			diff := from.percent() - to.percent()
+			for _, shard := range shards {
+				if shard.moved || shard.size == 0 || to.hasCopy(shard) ||
+					!awarenessAllowed(nodes, shard, from, to, awareness) {
+					continue
+				}
+				fromPercent := float64(from.used-shard.size) * 100 / float64(from.total)
+				toPercent := float64(to.used+shard.size) * 100 / float64(to.total)
+				if toPercent-fromPercent < diff && fromPercent-toPercent < diff {
+					return &balanceMove{shard: shard, from: from, to: to}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// awarenessAllowed check the target node is in the same awareness zone as the source node,
+// or there is no other copy of the shard in the zone of target node.
+func awarenessAllowed(nodes []*balanceNode, shard *balanceShard, from, to *balanceNode, awareness []string) bool {
+	for _, attr := range awareness {
+		value := to.attrs[attr]
+		if value == from.attrs[attr] {
+			continue
+		}
+		for _, node := range nodes {
+			if node != from && node.attrs[attr] == value && node.hasCopy(shard) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// applyBalanceMoves move the shards with reroute and wait for the relocation done,
+// return the number of moves accepted by the cluster.
+func applyBalanceMoves(client *elastic.Client, ctx ctx.Context, moves []*balanceMove, step string,
+	timeout, interval time.Duration) (int, error) {
+	service := client.ClusterReroute().Metric("master_node")
+	var accepted []*balanceMove
+	for _, move := range moves {
+		shard, err := strconv.Atoi(move.shard.shard)
+		if err != nil {
+			return 0, err
+		}
+		command := elastic.NewMoveAllocationCommand(move.shard.index, shard, move.from.name, move.to.name)
+		// the allocation deciders unknown by the plan (ex: the index filters) may reject the move,
+		// which fails the whole reroute, so check each move with dry run.
+		if _, err := client.ClusterReroute().DryRun(true).Metric("master_node").Add(command).Do(ctx); err != nil {
+			logrus.Warnf("%s: skip move %s[%s][%s] from %s to %s: %s", step, move.shard.index, move.shard.shard,
+				move.shard.prirep, move.from.name, move.to.name, err)
+			continue
+		}
+		service = service.Add(command)
+		accepted = append(accepted, move)
+		fmt.Printf("%s: move %s[%s][%s] (%s) from %s to %s\n", step, move.shard.index, move.shard.shard,
+			move.shard.prirep, formatBytes(move.shard.size), move.from.name, move.to.name)
+	}
+	if len(accepted) == 0 {
+		logrus.Warnf("%s: all the moves are rejected, skip the wave", step)
+		return 0, nil
+	}
+	if _, err := service.Do(ctx); err != nil {
+		return 0, err
+	}
+	moves = accepted
+
+	deadline := time.Now().Add(timeout)
+	for {
+		time.Sleep(interval)
+
+		res, err := client.ClusterHealth().Do(ctx)
+		if err != nil {
+			logrus.Warnf("get cluster health failed: %s", err)
+		} else if res.RelocatingShards == 0 {
+			fmt.Println(sgrBoldBlue(fmt.Sprintf("%s: relocation done.", step)))
+			return len(moves), nil
+		} else {
+			printBalanceRecoveries(client, ctx, step, moves)
+		}
+
+		if time.Now().After(deadline) {
+			return len(moves), fmt.Errorf("relocation not done in %s", timeout)
+		}
+	}
+}
+
+// printBalanceRecoveries print the progress of recoveries of the moved shards.
+func printBalanceRecoveries(client *elastic.Client, ctx ctx.Context, step string, moves []*balanceMove) {
+	res, err := client.CatRecoveryService().Do(ctx)
+	if err != nil {
+		logrus.Warnf("get recoveries failed: %s", err)
+		return
+	}
+
+	for _, recovery := range res.Recoverys {
+		if recovery.Stage == "done" {
+			continue
+		}
+		for _, move := range moves {
+			if recovery.Index == move.shard.index && recovery.Shard == move.shard.shard {
+				fmt.Printf("%s: %s[%s] %s -> %s, stage: %s, bytes: %s, time: %s\n", step, recovery.Index,
+					recovery.Shard, recovery.SourceHost, recovery.TargetHost, recovery.Stage,
+					recovery.BytesPercent, recovery.Time)
+				break
+			}
+		}
+	}
+}
+
+// node ip disk.total disk.percent planned excluded
+func printBalanceNodes(nodes []*balanceNode, before map[string]float64) {
+	sorted := append([]*balanceNode{}, nodes...)
+	sort.Sort(balanceNodes(sorted))
+
+	display := NewTableDisplay()
+	display.AddRow([]string{"node", "ip", "disk.total", "disk.percent", "planned", "excluded"})
+	for _, node := range sorted {
+		excluded := "-"
+		if node.excluded {
+			excluded = "*"
+		}
+		display.AddRow([]string{
+			node.name,
+			node.ip,
+			formatBytes(node.total),
+			fmt.Sprintf("%.1f%%", before[node.name]),
+			fmt.Sprintf("%.1f%%", node.percent()),
+			excluded})
+	}
+	display.Flush()
+}
+
+// order index shard prirep size from to from.percent to.percent
+func printBalanceMoves(moves []*balanceMove) {
+	display := NewTableDisplay()
+	display.AddRow([]string{"order", "index", "shard", "prirep", "size", "from", "to", "from.percent", "to.percent"})
+	for i, move := range moves {
+		display.AddRow([]string{
+			strconv.Itoa(i + 1),
+			move.shard.index,
+			move.shard.shard,
+			move.shard.prirep,
+			formatBytes(move.shard.size),
+			move.from.name,
+			move.to.name,
+			fmt.Sprintf("%.1f%%", move.fromPercent),
+			fmt.Sprintf("%.1f%%", move.toPercent)})
+	}
+	display.Flush()
+}
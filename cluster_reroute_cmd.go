@@ -0,0 +1,259 @@
+package main
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+// rerouteFlags is shared by all reroute commands.
+var rerouteFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "retry-failed",
+		Usage: "retry the allocation of shards which failed too many times (max_retry).",
+	},
+	cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "only simulate the commands, the cluster state is not changed.",
+	},
+	cli.BoolFlag{
+		Name:  "explain",
+		Usage: "print the decisions of the allocation deciders for the commands.",
+	},
+	cli.BoolFlag{
+		Name:  "yes, y",
+		Usage: "Answer the data loss conform of primary allocation.",
+	},
+}
+
+// reroute
+var clusterRerouteCommand = cli.Command{
+	Name:      "reroute",
+	Aliases:   []string{"r"},
+	Usage:     "Move, cancel or allocate the shards of elastic cluster.",
+	ArgsUsage: `[--retry-failed]`,
+	Description: `Execute an allocation command with the cluster reroute API, or with --retry-failed
+   only retry the allocation of shards which failed too many times.`,
+	Flags: rerouteFlags,
+	Subcommands: []cli.Command{
+		// cluster reroute move
+		clusterRerouteMoveCommand,
+		// cluster reroute cancel
+		clusterRerouteCancelCommand,
+		// cluster reroute allocate_replica
+		clusterRerouteAllocateReplicaCommand,
+		// cluster reroute allocate_stale_primary
+		clusterRerouteAllocateStalePrimaryCommand,
+		// cluster reroute allocate_empty_primary
+		clusterRerouteAllocateEmptyPrimaryCommand,
+	},
+	Action: func(context *cli.Context) error {
+		if !context.Bool("retry-failed") {
+			cli.ShowAppHelp(context)
+			return errors.New("cluster reroute must provide a command or --retry-failed")
+		}
+		return clusterRerouteCmd(context, nil)
+	},
+}
+
+// move                   index shard from_node to_node
+var clusterRerouteMoveCommand = cli.Command{
+	Name:        "move",
+	Usage:       "Move a started shard from one node to another node.",
+	ArgsUsage:   `index shard from_node to_node`,
+	Description: `Move a started shard, the node is the name or id of node.`,
+	Flags:       rerouteFlags,
+	Action: func(context *cli.Context) error {
+		args, err := getRerouteArgs(context, "move", 4)
+		if err != nil {
+			return err
+		}
+		return clusterRerouteCmd(context, elastic.NewMoveAllocationCommand(args.index, args.shard, args.nodes[0], args.nodes[1]))
+	},
+}
+
+// cancel                 index shard node
+var clusterRerouteCancelCommand = cli.Command{
+	Name:        "cancel",
+	Usage:       "Cancel the relocation or recovery of a shard on a node.",
+	ArgsUsage:   `index shard node`,
+	Description: `Cancel the relocation or recovery of a shard, cancel a primary shard need --allow-primary.`,
+	Flags: append([]cli.Flag{
+		cli.BoolFlag{
+			Name:  "allow-primary",
+			Usage: "allow to cancel the allocation of a primary shard.",
+		},
+	}, rerouteFlags...),
+	Action: func(context *cli.Context) error {
+		args, err := getRerouteArgs(context, "cancel", 3)
+		if err != nil {
+			return err
+		}
+		command := elastic.NewCancelAllocationCommand(args.index, args.shard, args.nodes[0])
+		if context.Bool("allow-primary") {
+			command.AllowPrimary(true)
+		}
+		return clusterRerouteCmd(context, command)
+	},
+}
+
+// allocate_replica       index shard node
+var clusterRerouteAllocateReplicaCommand = cli.Command{
+	Name:        "allocate_replica",
+	Usage:       "Allocate an unassigned replica shard to a node.",
+	ArgsUsage:   `index shard node`,
+	Description: `Allocate an unassigned replica shard, the allocation deciders are respected.`,
+	Flags:       rerouteFlags,
+	Action: func(context *cli.Context) error {
+		args, err := getRerouteArgs(context, "allocate_replica", 3)
+		if err != nil {
+			return err
+		}
+		return clusterRerouteCmd(context, elastic.NewAllocateReplicaAllocationCommand(args.index, args.shard, args.nodes[0]))
+	},
+}
+
+// allocate_stale_primary index shard node
+var clusterRerouteAllocateStalePrimaryCommand = cli.Command{
+	Name:      "allocate_stale_primary",
+	Usage:     "Allocate a primary shard to a node holding a stale copy.",
+	ArgsUsage: `index shard node`,
+	Description: `Allocate a primary shard to the node holding a stale copy of the shard,
+   the data written after the copy went stale is LOST.`,
+	Flags: rerouteFlags,
+	Action: func(context *cli.Context) error {
+		args, err := getRerouteArgs(context, "allocate_stale_primary", 3)
+		if err != nil {
+			return err
+		}
+		return clusterRerouteCmd(context, elastic.NewAllocateStalePrimaryAllocationCommand(args.index, args.shard, args.nodes[0], true))
+	},
+}
+
+// allocate_empty_primary index shard node
+var clusterRerouteAllocateEmptyPrimaryCommand = cli.Command{
+	Name:      "allocate_empty_primary",
+	Usage:     "Allocate an empty primary shard to a node.",
+	ArgsUsage: `index shard node`,
+	Description: `Allocate an empty primary shard to the node,
+   ALL the data of the shard is LOST, if a node holding a copy rejoins, the copy is deleted.`,
+	Flags: rerouteFlags,
+	Action: func(context *cli.Context) error {
+		args, err := getRerouteArgs(context, "allocate_empty_primary", 3)
+		if err != nil {
+			return err
+		}
+		return clusterRerouteCmd(context, elastic.NewAllocateEmptyPrimaryAllocationCommand(args.index, args.shard, args.nodes[0], true))
+	},
+}
+
+// rerouteArgs is the args of reroute command: index shard node [node].
+type rerouteArgs struct {
+	index string
+	shard int
+	nodes []string
+}
+
+func getRerouteArgs(context *cli.Context, name string, n int) (*rerouteArgs, error) {
+	if context.NArg() != n {
+		fmt.Printf("Incorrect Usage.\n\n")
+		cli.ShowCommandHelp(context, name)
+		return nil, fmt.Errorf("cluster reroute %s must provide %s", name, context.Command.ArgsUsage)
+	}
+
+	shard, err := strconv.Atoi(context.Args().Get(1))
+	if err != nil || shard < 0 {
+		return nil, fmt.Errorf("invalid shard number %s", context.Args().Get(1))
+	}
+	return &rerouteArgs{
+		index: context.Args().Get(0),
+		shard: shard,
+		nodes: context.Args()[2:],
+	}, nil
+}
+
+// isDataLossCommand check the command allocate a primary with data loss.
+func isDataLossCommand(command elastic.AllocationCommand) bool {
+	switch command.(type) {
+	case *elastic.AllocateStalePrimaryAllocationCommand, *elastic.AllocateEmptyPrimaryAllocationCommand:
+		return true
+	}
+	return false
+}
+
+func clusterRerouteCmd(context *cli.Context, command elastic.AllocationCommand) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	dryRun := context.Bool("dry-run")
+	if command != nil && isDataLossCommand(command) && !dryRun {
+		fmt.Println(sgrBoldRed(fmt.Sprintf("[Attention] %s will LOSE data of the shard! type (yes) to conform reroute.", command.Name())))
+		if !context.Bool("yes") {
+			args := context.Args()
+			YesOrDie(fmt.Sprintf("%s %s[%s] on %s", command.Name(), args.Get(0), args.Get(1), args.Get(2)))
+		}
+	}
+
+	service := client.ClusterReroute().
+		DryRun(dryRun).
+		Explain(context.Bool("explain")).
+		RetryFailed(context.Bool("retry-failed")).
+		Metric("master_node")
+	if command != nil {
+		service = service.Add(command)
+	}
+	res, err := service.Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	if context.Bool("explain") {
+		printRerouteExplanations(res.Explanations)
+		fmt.Println()
+	}
+
+	switch {
+	case dryRun:
+		fmt.Println(sgrBoldBlue("[OK] dry run, the cluster state is not changed."))
+	case res.Acknowledged:
+		fmt.Println(sgrBoldBlue("[OK] cluster reroute acknowledged."))
+	default:
+		fmt.Println(sgrBoldRed("[WARN] cluster reroute not acknowledged, check the cluster state later."))
+	}
+	return nil
+}
+
+// command parameters decider decision explanation
+func printRerouteExplanations(explanations []*elastic.ClusterRerouteExplanation) {
+	display := NewTableDisplay()
+	display.AddRow([]string{"command", "parameters", "decider", "decision", "explanation"})
+	for _, explanation := range explanations {
+		var params []string
+		for _, key := range []string{"index", "shard", "node", "from_node", "to_node"} {
+			if value, ok := explanation.Parameters[key]; ok {
+				params = append(params, fmt.Sprintf("%s=%v", key, value))
+			}
+		}
+		for _, decision := range explanation.Decisions {
+			display.AddRow([]string{
+				explanation.Command,
+				strings.Join(params, ","),
+				decision.Decider,
+				decision.Decision,
+				decision.Explanation})
+		}
+	}
+	display.Flush()
+}
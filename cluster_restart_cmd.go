@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	ctx "context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+const allocationEnableStr = "cluster.routing.allocation.enable"
+
+// restartNode is a data node in the rolling restart plan,
+// and is also the data of --restart-cmd template.
+type restartNode struct {
+	Name   string
+	Host   string
+	IP     string
+	Role   string
+	Master bool
+}
+
+// restartNodes sort the nodes by name, the elected master is the last one.
+type restartNodes []*restartNode
+
+func (n restartNodes) Len() int      { return len(n) }
+func (n restartNodes) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
+func (n restartNodes) Less(i, j int) bool {
+	if n[i].Master != n[j].Master {
+		return n[j].Master
+	}
+	return n[i].Name < n[j].Name
+}
+
+// rolling restart
+var clusterRollingRestartCommand = cli.Command{
+	Name:      "rolling-restart",
+	Aliases:   []string{"rr"},
+	Usage:     "Restart the data nodes of elastic cluster one by one.",
+	ArgsUsage: `-r 'ssh {{.Host}} sudo systemctl restart elasticsearch'`,
+	Description: `Restart the data nodes one by one, for each node: disable replica allocation, synced flush,
+   run the restart command, wait for the node rejoin, enable allocation and wait for green status.
+   The restart command is a template with the node fields: {{.Name}}, {{.Host}}, {{.IP}}.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "restart-cmd, r",
+			Value: "",
+			Usage: "set the shell command to restart a node, ex: 'ssh {{.Host}} sudo systemctl restart elasticsearch'.",
+		},
+		cli.StringFlag{
+			Name:  "nodes, n",
+			Value: "",
+			Usage: "only restart the nodes with name (node1,node2), default all data nodes.",
+		},
+		cli.StringFlag{
+			Name:  "resume-from",
+			Value: "",
+			Usage: "resume the rolling restart from the node with name, the nodes before it are skipped.",
+		},
+		cli.DurationFlag{
+			Name:  "timeout, t",
+			Value: 30 * time.Minute,
+			Usage: "set the max time to wait for the node rejoin and the cluster green of each node.",
+		},
+		cli.DurationFlag{
+			Name:  "leave-timeout",
+			Value: 2 * time.Minute,
+			Usage: "set the max time to wait for the node leave the cluster after the restart command.",
+		},
+		cli.DurationFlag{
+			Name:  "interval, i",
+			Value: 10 * time.Second,
+			Usage: "set the interval to check the node and cluster status.",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only print the plan of rolling restart.",
+		},
+		cli.BoolFlag{
+			Name:  "yes, y",
+			Usage: "Answer rolling restart conform.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return clusterRollingRestartCmd(context)
+	},
+}
+
+func clusterRollingRestartCmd(context *cli.Context) error {
+	restartCmd := strings.TrimSpace(context.String("restart-cmd"))
+	if restartCmd == "" && !context.Bool("dry-run") {
+		cli.ShowCommandHelp(context, "rolling-restart")
+		return errors.New("cluster rolling-restart must provide -r restart command")
+	}
+
+	t, err := template.New("restartCmd").Parse(restartCmd)
+	if err != nil {
+		return fmt.Errorf("invalid restart command: %s", err)
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	nodes, err := getRestartPlan(client, ctx, context.String("nodes"), context.String("resume-from"))
+	if err != nil {
+		return err
+	}
+
+	printRestartPlan(nodes, t)
+
+	// restart a node of the cluster not green may take down the last copy of shards.
+	health, err := client.ClusterHealth().Do(ctx)
+	if err != nil {
+		return err
+	}
+	if health.Status != "green" {
+		if !context.Bool("dry-run") {
+			return fmt.Errorf("cluster is %s, rolling restart must start with the green cluster", health.Status)
+		}
+		logrus.Warnf("cluster is %s, rolling restart must start with the green cluster", health.Status)
+	}
+	if context.Bool("dry-run") {
+		return nil
+	}
+
+	fmt.Println(sgrBoldBlue("[Attention] Rolling restart above nodes? type (yes) to conform restart."))
+	if !context.Bool("yes") {
+		YesOrDie(fmt.Sprintf("%d nodes", len(nodes)))
+	}
+
+	timeout := context.Duration("timeout")
+	interval := context.Duration("interval")
+	for i, node := range nodes {
+		step := fmt.Sprintf("[%d/%d] %s", i+1, len(nodes), node.Name)
+		if err := restartOneNode(client, ctx, node, t, step, timeout, context.Duration("leave-timeout"), interval); err != nil {
+			return fmt.Errorf("%s: %s, fix it and resume with --resume-from %s", step, err, node.Name)
+		}
+		fmt.Println(sgrBoldBlue(fmt.Sprintf("%s: restarted, cluster is green.", step)))
+	}
+
+	fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] all %d nodes restarted.", len(nodes))))
+	return nil
+}
+
+// getRestartPlan get the data nodes to restart in order, the master node is the last one.
+func getRestartPlan(client *elastic.Client, ctx ctx.Context, names, resumeFrom string) ([]*restartNode, error) {
+	res, err := client.CatNodesService().Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := map[string]struct{}{}
+	for _, name := range DeDuplicate(strings.Split(names, ",")) {
+		filter[name] = struct{}{}
+	}
+
+	var nodes []*restartNode
+	for _, nodeInfo := range res.Nodes {
+		if !strings.Contains(nodeInfo.NodeRole, "d") {
+			continue
+		}
+		if _, ok := filter[nodeInfo.Name]; len(filter) > 0 && !ok {
+			continue
+		}
+		delete(filter, nodeInfo.Name)
+
+		nodes = append(nodes, &restartNode{
+			Name:   nodeInfo.Name,
+			Host:   nodeInfo.Host,
+			IP:     nodeInfo.IP,
+			Role:   nodeInfo.NodeRole,
+			Master: nodeInfo.Master == "*",
+		})
+	}
+
+	if len(filter) > 0 {
+		var missing []string
+		for name := range filter {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%s not data nodes of elastic cluster", strings.Join(missing, ","))
+	}
+
+	// restart the elected master at last to avoid more than one election.
+	sort.Sort(restartNodes(nodes))
+
+	if resumeFrom == "" {
+		return nodes, nil
+	}
+	for i, node := range nodes {
+		if node.Name == resumeFrom {
+			return nodes[i:], nil
+		}
+	}
+	return nil, fmt.Errorf("resume node %s is not in the rolling restart plan", resumeFrom)
+}
+
+// order name ip host role master command
+func printRestartPlan(nodes []*restartNode, t *template.Template) error {
+	display := NewTableDisplay()
+	display.AddRow([]string{"order", "name", "ip", "host", "role", "master", "command"})
+	for i, node := range nodes {
+		var buffer bytes.Buffer
+		if err := t.Execute(&buffer, node); err != nil {
+			return err
+		}
+
+		master := "-"
+		if node.Master {
+			master = "*"
+		}
+		display.AddRow([]string{
+			strconv.Itoa(i + 1),
+			node.Name,
+			node.IP,
+			node.Host,
+			node.Role,
+			master,
+			buffer.String()})
+	}
+
+	display.Flush()
+	return nil
+}
+
+// restartOneNode restart the node and wait for the cluster green, the replica allocation
+// is enabled again if the restart failed.
+func restartOneNode(client *elastic.Client, ctx ctx.Context, node *restartNode, t *template.Template, step string,
+	timeout, leaveTimeout, interval time.Duration) (err error) {
+	var buffer bytes.Buffer
+	if err := t.Execute(&buffer, node); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: disable replica allocation.\n", step)
+	if err := setAllocationEnable(client, ctx, "primaries"); err != nil {
+		return err
+	}
+	enabled := false
+	defer func() {
+		if err == nil || enabled {
+			return
+		}
+		if resetErr := setAllocationEnable(client, ctx, ""); resetErr != nil {
+			err = fmt.Errorf("%s, replica allocation is still disabled, reset %s failed: %s", err, allocationEnableStr, resetErr)
+			return
+		}
+		logrus.Warnf("%s: replica allocation enabled after the failure", step)
+	}()
+
+	fmt.Printf("%s: synced flush.\n", step)
+	res, err := client.SyncedFlush().Do(ctx)
+	if err != nil {
+		return err
+	}
+	if res.Shards.Failed > 0 {
+		logrus.Warnf("synced flush failed on %d of %d shards, the recovery may be slower", res.Shards.Failed, res.Shards.Total)
+	}
+
+	fmt.Printf("%s: run %s\n", step, buffer.String())
+	cmd := exec.Command("sh", "-c", buffer.String())
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("restart command failed: %s", err)
+	}
+
+	fmt.Printf("%s: wait for the node leave and rejoin the cluster.\n", step)
+	if err := waitForNodePresent(client, ctx, node.Name, false, leaveTimeout, interval); err != nil {
+		logrus.Warnf("%s, maybe it has restarted already", err)
+	}
+	if err := waitForNodePresent(client, ctx, node.Name, true, timeout, interval); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: enable allocation.\n", step)
+	if err := setAllocationEnable(client, ctx, ""); err != nil {
+		return err
+	}
+	enabled = true
+
+	fmt.Printf("%s: wait for green status.\n", step)
+	return waitForClusterStatus(client, ctx, "green", timeout, interval)
+}
+
+// setAllocationEnable set the transient "cluster.routing.allocation.enable", reset it with empty value.
+func setAllocationEnable(client *elastic.Client, ctx ctx.Context, value string) error {
+	var setting interface{}
+	if value != "" {
+		setting = value
+	}
+
+	body := map[string]interface{}{
+		"transient": map[string]interface{}{allocationEnableStr: setting},
+	}
+	_, err := client.ClusterPutSettings().FlatSettings(true).BodyJson(body).Do(ctx)
+	return err
+}
+
+// waitForNodePresent wait until the node with name joined (or left) the cluster.
+func waitForNodePresent(client *elastic.Client, ctx ctx.Context, name string, present bool,
+	timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		res, err := client.CatNodesService().Do(ctx)
+		if err != nil {
+			logrus.Warnf("get nodes failed: %s", err)
+		} else {
+			found := false
+			for _, nodeInfo := range res.Nodes {
+				if nodeInfo.Name == name {
+					found = true
+					break
+				}
+			}
+			if found == present {
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			if present {
+				return fmt.Errorf("node %s not joined the cluster in %s", name, timeout)
+			}
+			return fmt.Errorf("node %s not left the cluster in %s", name, timeout)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// waitForClusterStatus wait until the cluster health is status.
+func waitForClusterStatus(client *elastic.Client, ctx ctx.Context, status string,
+	timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		res, err := client.ClusterHealth().Do(ctx)
+		if err != nil {
+			logrus.Warnf("get cluster health failed: %s", err)
+		} else if res.Status == status {
+			return nil
+		} else {
+			fmt.Printf("cluster is %s, initializing: %d, unassigned: %d\n", res.Status, res.InitializingShards, res.UnassignedShards)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("cluster not %s in %s", status, timeout)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	ctx "context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+var dataCommand = cli.Command{
+	Name:    "data",
+	Aliases: []string{"d"},
+	Usage:   "Elastic documents import and export cmd.",
+	Subcommands: []cli.Command{
+		// data import
+		dataImportCommand,
+		// data export
+		dataExportCommand,
+	},
+}
+
+// import                 index file.ndjson|file.csv|-
+var dataImportCommand = cli.Command{
+	Name:      "import",
+	Usage:     "Import the documents of ndjson or csv file into an index.",
+	ArgsUsage: `index file.ndjson|file.csv|- [--id-field id] [--workers 2] [--batch 1000]`,
+	Description: `Index the documents of file (or stdin with '-') with the bulk processor, one json document per
+   line of ndjson, or one document per row of csv with the header row as the field names. The gzip file
+   is decompressed automatically. The rejected documents are written to the rejects file with the errors.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Value: "",
+			Usage: "set the format of file('ndjson' or 'csv'), default by the extension of file or ndjson.",
+		},
+		cli.StringFlag{
+			Name:  "id-field",
+			Value: "",
+			Usage: "set the field as the document id, default the id is generated.",
+		},
+		cli.StringFlag{
+			Name:  "type",
+			Value: "",
+			Usage: "set the document type, default the existing type of index or _doc.",
+		},
+		cli.StringFlag{
+			Name:  "delimiter",
+			Value: ",",
+			Usage: "set the field delimiter of csv.",
+		},
+		cli.IntFlag{
+			Name:  "workers, w",
+			Value: 2,
+			Usage: "set the number of bulk workers.",
+		},
+		cli.IntFlag{
+			Name:  "batch, b",
+			Value: 1000,
+			Usage: "set the number of documents of a bulk request.",
+		},
+		cli.DurationFlag{
+			Name:  "flush-interval",
+			Value: 5 * time.Second,
+			Usage: "set the interval to flush the documents not committed.",
+		},
+		cli.StringFlag{
+			Name:  "rejects",
+			Value: "",
+			Usage: "set the file of the rejected documents, default index-rejects.ndjson.",
+		},
+		cli.DurationFlag{
+			Name:  "interval, i",
+			Value: 5 * time.Second,
+			Usage: "set the interval to print the progress.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 2 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "import")
+			logrus.Fatalf("Must provide index and file for data import command!")
+		}
+
+		return dataImportCmd(context)
+	},
+}
+
+// openDataFile open the file or stdin with "-", the gzip file is decompressed.
+func openDataFile(name string) (io.ReadCloser, error) {
+	file := os.Stdin
+	if name != "-" {
+		var err error
+		if file, err = os.Open(name); err != nil {
+			return nil, err
+		}
+	}
+
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &dataFileReader{Reader: gz, closers: []io.Closer{gz, file}}, nil
+	}
+	return &dataFileReader{Reader: reader, closers: []io.Closer{file}}, nil
+}
+
+// dataFileReader close the decompressor and the file.
+type dataFileReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *dataFileReader) Close() error {
+	var err error
+	for _, closer := range r.closers {
+		if e := closer.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// dataFormat get the format of file by the extension, ex: a.csv.gz is csv.
+func dataFormat(name string) string {
+	ext := filepath.Ext(strings.TrimSuffix(name, ".gz"))
+	if ext == ".csv" {
+		return "csv"
+	}
+	return "ndjson"
+}
+
+// dataImporter add the documents to the bulk processor, and write the rejected documents.
+type dataImporter struct {
+	index     string
+	typ       string
+	idField   string
+	processor *elastic.BulkProcessor
+
+	rejectsName string
+	rejectsMu   sync.Mutex
+	rejects     *os.File
+
+	read      int64
+	succeeded int64
+	failed    int64
+
+	// commitErr is the error of the bulk request failed after the retries.
+	commitErrMu sync.Mutex
+	commitErr   error
+}
+
+// add the document to the bulk processor, the document without id field is rejected.
+func (im *dataImporter) add(doc map[string]interface{}) {
+	atomic.AddInt64(&im.read, 1)
+	req := elastic.NewBulkIndexRequest().Index(im.index).Type(im.typ).Doc(doc)
+	if im.idField != "" {
+		id, ok := doc[im.idField]
+		if !ok || id == nil {
+			atomic.AddInt64(&im.failed, 1)
+			im.reject("", &elastic.ErrorDetails{Type: "missing_id", Reason: "field " + im.idField + " is missing"}, doc)
+			return
+		}
+		req = req.Id(fmt.Sprintf("%v", id))
+	}
+	im.processor.Add(req)
+}
+
+// after is called after each bulk request, the failed items are rejected. If the whole request failed,
+// the requests are kept in the bulk processor and sent again with the next commit, so they are not
+// rejected, and the import is aborted.
+func (im *dataImporter) after(executionID int64, requests []elastic.BulkableRequest, res *elastic.BulkResponse, err error) {
+	if err != nil {
+		im.commitErrMu.Lock()
+		if im.commitErr == nil {
+			im.commitErr = fmt.Errorf("bulk request of %d documents failed: %s, the import is aborted and the batch is not committed",
+				len(requests), err)
+		}
+		im.commitErrMu.Unlock()
+		return
+	}
+
+	for i, req := range requests {
+		var item *elastic.BulkResponseItem
+		if res != nil && i < len(res.Items) {
+			// the item is keyed by the action, ex: index.
+			for _, actionItem := range res.Items[i] {
+				item = actionItem
+			}
+		}
+
+		var reason *elastic.ErrorDetails
+		switch {
+		case item == nil:
+			reason = &elastic.ErrorDetails{Type: "bulk_exception", Reason: "no response of the document"}
+		case item.Error != nil:
+			reason = item.Error
+		case item.Status >= 300:
+			reason = &elastic.ErrorDetails{Type: "bulk_exception", Reason: fmt.Sprintf("status %d", item.Status)}
+		default:
+			atomic.AddInt64(&im.succeeded, 1)
+			continue
+		}
+
+		atomic.AddInt64(&im.failed, 1)
+		var doc interface{}
+		if lines, err := req.Source(); err == nil && len(lines) > 1 {
+			doc = json.RawMessage(lines[1])
+		}
+		var id string
+		if item != nil {
+			id = item.Id
+		}
+		im.reject(id, reason, doc)
+	}
+}
+
+// commitError get the error of the failed bulk request.
+func (im *dataImporter) commitError() error {
+	im.commitErrMu.Lock()
+	defer im.commitErrMu.Unlock()
+	return im.commitErr
+}
+
+// reject write the document with the error to the rejects file, the file is created at the first reject.
+func (im *dataImporter) reject(id string, reason *elastic.ErrorDetails, doc interface{}) {
+	im.rejectsMu.Lock()
+	defer im.rejectsMu.Unlock()
+
+	if im.rejects == nil {
+		file, err := os.Create(im.rejectsName)
+		if err != nil {
+			logrus.Fatalf("create rejects file %s failed: %s", im.rejectsName, err)
+		}
+		im.rejects = file
+	}
+
+	line, err := json.Marshal(map[string]interface{}{"_id": id, "error": reason, "doc": doc})
+	if err != nil {
+		logrus.Warnf("marshal rejected document failed: %s", err)
+		return
+	}
+	if _, err := im.rejects.Write(append(line, '\n')); err != nil {
+		logrus.Fatalf("write rejects file %s failed: %s", im.rejectsName, err)
+	}
+}
+
+// importNDJSON add the json document of each line.
+func (im *dataImporter) importNDJSON(reader io.Reader) error {
+	lines := bufio.NewReader(reader)
+	for n := 1; ; n++ {
+		if err := im.commitError(); err != nil {
+			return err
+		}
+		line, err := lines.ReadBytes('\n')
+		if text := strings.TrimSpace(string(line)); text != "" {
+			var doc map[string]interface{}
+			decoder := json.NewDecoder(strings.NewReader(text))
+			decoder.UseNumber()
+			if e := decoder.Decode(&doc); e != nil {
+				atomic.AddInt64(&im.read, 1)
+				atomic.AddInt64(&im.failed, 1)
+				im.reject("", &elastic.ErrorDetails{Type: "parse_exception", Reason: fmt.Sprintf("line %d: %s", n, e)}, text)
+			} else {
+				im.add(doc)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// importCSV add the document of each row, the header row is the field names and the empty values are omitted.
+func (im *dataImporter) importCSV(reader io.Reader, delimiter rune) error {
+	rows := csv.NewReader(reader)
+	rows.Comma = delimiter
+	header, err := rows.Read()
+	if err != nil {
+		return fmt.Errorf("read csv header failed: %s", err)
+	}
+
+	for {
+		if err := im.commitError(); err != nil {
+			return err
+		}
+		row, err := rows.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if parseErr, ok := err.(*csv.ParseError); ok && parseErr.Err == csv.ErrFieldCount {
+			atomic.AddInt64(&im.read, 1)
+			atomic.AddInt64(&im.failed, 1)
+			im.reject("", &elastic.ErrorDetails{Type: "parse_exception", Reason: err.Error()}, row)
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		doc := make(map[string]interface{})
+		for i, value := range row {
+			if value != "" {
+				doc[header[i]] = value
+			}
+		}
+		im.add(doc)
+	}
+}
+
+// printProgress print the documents and the rate since the last progress.
+func (im *dataImporter) printProgress(lastDone int64, elapsed time.Duration) int64 {
+	succeeded, failed := atomic.LoadInt64(&im.succeeded), atomic.LoadInt64(&im.failed)
+	done := succeeded + failed
+	progress := fmt.Sprintf("read: %d, indexed: %d, rejected: %d", atomic.LoadInt64(&im.read), succeeded, failed)
+	if elapsed > 0 {
+		progress += fmt.Sprintf(", rate: %.0f docs/s", float64(done-lastDone)/elapsed.Seconds())
+	}
+	fmt.Println(progress)
+	return done
+}
+
+func dataImportCmd(context *cli.Context) error {
+	index, name := context.Args().Get(0), context.Args().Get(1)
+	format := context.String("format")
+	if format == "" {
+		format = dataFormat(name)
+	}
+	if format != "ndjson" && format != "csv" {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	delimiter := []rune(context.String("delimiter"))
+	if len(delimiter) != 1 {
+		return fmt.Errorf("invalid csv delimiter %q", context.String("delimiter"))
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	typ := context.String("type")
+	if typ == "" {
+		exists, err := client.IndexExists(index).Do(ctx)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if typ, err = getIndexMappingType(client, ctx, index); err != nil {
+				return err
+			}
+		} else {
+			logrus.Warnf("index %s does not exist, it's created with the dynamic mappings", index)
+			typ = "_doc"
+		}
+	}
+
+	reader, err := openDataFile(name)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	im := &dataImporter{
+		index:       index,
+		typ:         typ,
+		idField:     context.String("id-field"),
+		rejectsName: context.String("rejects"),
+	}
+	if im.rejectsName == "" {
+		im.rejectsName = index + "-rejects.ndjson"
+	}
+	im.processor, err = client.BulkProcessor().
+		Name("import-" + index).
+		Workers(context.Int("workers")).
+		BulkActions(context.Int("batch")).
+		FlushInterval(context.Duration("flush-interval")).
+		Backoff(elastic.NewSimpleBackoff(1000, 2000, 4000, 8000)).
+		After(im.after).
+		Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	progressDone := make(chan struct{})
+	go func() {
+		defer close(progressDone)
+		ticker := time.NewTicker(context.Duration("interval"))
+		defer ticker.Stop()
+		var lastDone int64
+		lastTime := time.Now()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				lastDone = im.printProgress(lastDone, now.Sub(lastTime))
+				lastTime = now
+			}
+		}
+	}()
+
+	start := time.Now()
+	if format == "csv" {
+		err = im.importCSV(reader, delimiter[0])
+	} else {
+		err = im.importNDJSON(reader)
+	}
+	// commit the documents in the bulk processor before return.
+	if closeErr := im.processor.Close(); err == nil {
+		err = closeErr
+	}
+	if commitErr := im.commitError(); commitErr != nil {
+		err = commitErr
+	}
+	close(stop)
+	<-progressDone
+	if im.rejects != nil {
+		im.rejects.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	im.printProgress(0, time.Since(start))
+	if im.failed > 0 {
+		return fmt.Errorf("%d documents rejected, see %s", im.failed, im.rejectsName)
+	}
+
+	fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] %d documents imported into %s.", im.succeeded, index)))
+	return nil
+}
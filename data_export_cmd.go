@@ -0,0 +1,535 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	ctx "context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+// export                 index
+var dataExportCommand = cli.Command{
+	Name:      "export",
+	Usage:     "Export the documents of an index to ndjson or csv file.",
+	ArgsUsage: `index [--query json] [--fields a,b] [--format ndjson|csv] [--out file.gz] [--slices 2]`,
+	Description: `Scroll the documents matched the query and write them to the file (stdout by default), the file
+   ends with .gz is compressed. The scroll is sliced to run in parallel with --slices. The progress is saved
+   to the checkpoint file of --out after each batch, Ctrl-C stops after the current batches and the export
+   continues with --resume before the scroll expires (--keep-alive).`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "query, q",
+			Value: "",
+			Usage: "only export the documents matched the query json.",
+		},
+		cli.StringFlag{
+			Name:  "fields",
+			Value: "",
+			Usage: "only export the fields, ex: host,user.name, must provide with csv.",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Value: "ndjson",
+			Usage: "set the format of output('ndjson' (default), or 'csv').",
+		},
+		cli.StringFlag{
+			Name:  "out, o",
+			Value: "",
+			Usage: "set the output file, compressed with gzip if ends with .gz, default stdout.",
+		},
+		cli.BoolFlag{
+			Name:  "meta",
+			Usage: "export the _index, _type and _id of documents.",
+		},
+		cli.IntFlag{
+			Name:  "slices",
+			Value: 1,
+			Usage: "set the number of scroll slices to export in parallel.",
+		},
+		cli.IntFlag{
+			Name:  "size",
+			Value: 1000,
+			Usage: "set the batch size of scroll of each slice.",
+		},
+		cli.StringFlag{
+			Name:  "keep-alive",
+			Value: "5m",
+			Usage: "set the time to keep the scroll alive between batches, and before resume.",
+		},
+		cli.BoolFlag{
+			Name:  "resume",
+			Usage: "continue the export of --out from the checkpoint.",
+		},
+		cli.DurationFlag{
+			Name:  "interval, i",
+			Value: 5 * time.Second,
+			Usage: "set the interval to print the progress.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "export")
+			logrus.Fatalf("Must provide index for data export command!")
+		}
+		if format := context.String("format"); format != "ndjson" && format != "csv" {
+			return fmt.Errorf("unknown format %q", format)
+		}
+		if context.String("format") == "csv" && context.String("fields") == "" {
+			return errors.New("data export csv must provide --fields")
+		}
+		if query := context.String("query"); query != "" && !isJSON(query) {
+			return fmt.Errorf("invalid query json %s", query)
+		}
+		if context.Int("slices") < 1 || context.Int("size") < 1 {
+			return errors.New("data export --slices and --size must be positive")
+		}
+		if context.Bool("resume") && context.String("out") == "" {
+			return errors.New("data export --resume must provide --out")
+		}
+
+		return dataExportCmd(context)
+	},
+}
+
+// exportCheckpoint is the progress of export, saved after each batch written.
+type exportCheckpoint struct {
+	Index  string `json:"index"`
+	Query  string `json:"query"`
+	Fields string `json:"fields"`
+	Format string `json:"format"`
+	Meta   bool   `json:"meta"`
+	Total  int64  `json:"total"`
+	Docs   int64  `json:"docs"`
+	// Offset is the size of output after the last batch written.
+	Offset int64 `json:"offset"`
+	// Stopped is true if the export is stopped by Ctrl-C, otherwise the batches
+	// fetched but not written are lost.
+	Stopped bool               `json:"stopped"`
+	Slices  []*exportSliceInfo `json:"slices"`
+}
+
+// exportSliceInfo is the scroll of slice.
+type exportSliceInfo struct {
+	ScrollID string `json:"scroll_id"`
+	Docs     int64  `json:"docs"`
+	Done     bool   `json:"done"`
+}
+
+// dataExporter write the batches of all the slices to the output, and save the checkpoint.
+type dataExporter struct {
+	mu         sync.Mutex
+	out        *os.File
+	gzip       bool
+	fields     []string
+	checkpoint *exportCheckpoint
+	// checkpointName is empty if the output is stdout.
+	checkpointName string
+
+	// docs is the number of documents written, guarded by mu.
+	docs    int64
+	stopped int32
+}
+
+// write the documents of the batch and save the checkpoint of slice.
+func (ex *dataExporter) write(slice int, scrollID string, hits []*elastic.SearchHit) error {
+	var buf bytes.Buffer
+	for _, hit := range hits {
+		if err := ex.formatHit(&buf, hit); err != nil {
+			return err
+		}
+	}
+
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	if err := ex.writeBytes(buf.Bytes()); err != nil {
+		return err
+	}
+	ex.docs += int64(len(hits))
+
+	info := ex.checkpoint.Slices[slice]
+	info.ScrollID = scrollID
+	info.Docs += int64(len(hits))
+	ex.checkpoint.Docs += int64(len(hits))
+	return ex.saveCheckpoint()
+}
+
+// writeBytes write the data to the output, each write is a gzip member if compressed,
+// so the output can be truncated to the checkpoint and appended.
+func (ex *dataExporter) writeBytes(data []byte) error {
+	if !ex.gzip {
+		_, err := ex.out.Write(data)
+		return err
+	}
+
+	gz := gzip.NewWriter(ex.out)
+	if _, err := gz.Write(data); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// formatHit format the document as a json line, or a csv row of the fields.
+func (ex *dataExporter) formatHit(buf *bytes.Buffer, hit *elastic.SearchHit) error {
+	var source json.RawMessage
+	if hit.Source != nil {
+		source = *hit.Source
+	} else {
+		source = json.RawMessage("{}")
+	}
+
+	if ex.checkpoint.Format == "ndjson" {
+		var line []byte
+		var err error
+		if ex.checkpoint.Meta {
+			line, err = json.Marshal(map[string]interface{}{"_index": hit.Index, "_type": hit.Type, "_id": hit.Id, "_source": source})
+		} else {
+			line, err = compactJSON(source)
+		}
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		return nil
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(source, &doc); err != nil {
+		return err
+	}
+	var row []string
+	if ex.checkpoint.Meta {
+		row = append(row, hit.Index, hit.Type, hit.Id)
+	}
+	for _, field := range ex.fields {
+		row = append(row, csvValue(fieldValue(doc, field)))
+	}
+	writer := csv.NewWriter(buf)
+	writer.Write(row)
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeHeader write the csv header of the fields.
+func (ex *dataExporter) writeHeader() error {
+	var row []string
+	if ex.checkpoint.Meta {
+		row = append(row, "_index", "_type", "_id")
+	}
+	row = append(row, ex.fields...)
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(row)
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	if err := ex.writeBytes(buf.Bytes()); err != nil {
+		return err
+	}
+	return ex.saveCheckpoint()
+}
+
+// saveCheckpoint save the checkpoint with the offset of output, the file is replaced atomically.
+func (ex *dataExporter) saveCheckpoint() error {
+	if ex.checkpointName == "" {
+		return nil
+	}
+	offset, err := ex.out.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	ex.checkpoint.Offset = offset
+
+	data, err := json.Marshal(ex.checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp := ex.checkpointName + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ex.checkpointName)
+}
+
+// compactJSON remove the spaces of json.
+func compactJSON(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fieldValue get the value of the dotted field, ex: user.name is {"user": {"name": x}} or {"user.name": x}.
+func fieldValue(doc map[string]interface{}, field string) interface{} {
+	if value, ok := doc[field]; ok {
+		return value
+	}
+	parts := strings.SplitN(field, ".", 2)
+	if len(parts) == 2 {
+		if nested, ok := doc[parts[0]].(map[string]interface{}); ok {
+			return fieldValue(nested, parts[1])
+		}
+	}
+	return nil
+}
+
+// csvValue format the value of csv, the objects and arrays are json.
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+func dataExportCmd(context *cli.Context) error {
+	index := context.Args().Get(0)
+	ex := &dataExporter{out: os.Stdout}
+	if context.String("fields") != "" {
+		ex.fields = strings.Split(context.String("fields"), ",")
+	}
+	// the progress is printed to stderr if the documents are written to stdout.
+	progressOut := os.Stderr
+
+	if name := context.String("out"); name != "" {
+		progressOut = os.Stdout
+		ex.gzip = strings.HasSuffix(name, ".gz")
+		ex.checkpointName = name + ".checkpoint"
+
+		var err error
+		if context.Bool("resume") {
+			if ex.checkpoint, err = loadExportCheckpoint(ex.checkpointName, index, context); err != nil {
+				return err
+			}
+			if ex.out, err = os.OpenFile(name, os.O_RDWR, 0644); err != nil {
+				return err
+			}
+			// the batches written after the checkpoint are written again.
+			if err := ex.out.Truncate(ex.checkpoint.Offset); err != nil {
+				return err
+			}
+			if _, err := ex.out.Seek(ex.checkpoint.Offset, io.SeekStart); err != nil {
+				return err
+			}
+			if !ex.checkpoint.Stopped {
+				logrus.Warnf("the export was not stopped by Ctrl-C, up to %d documents per slice may be missing", context.Int("size"))
+			}
+			ex.checkpoint.Stopped = false
+			ex.docs = ex.checkpoint.Docs
+		} else {
+			if _, err := os.Stat(ex.checkpointName); err == nil {
+				return fmt.Errorf("checkpoint %s exists, continue with --resume or remove it", ex.checkpointName)
+			}
+			if ex.out, err = os.Create(name); err != nil {
+				return err
+			}
+		}
+		defer ex.out.Close()
+	}
+	if ex.checkpoint == nil {
+		ex.checkpoint = &exportCheckpoint{
+			Index:  index,
+			Query:  context.String("query"),
+			Fields: context.String("fields"),
+			Format: context.String("format"),
+			Meta:   context.Bool("meta"),
+			Slices: make([]*exportSliceInfo, context.Int("slices")),
+		}
+		for i := range ex.checkpoint.Slices {
+			ex.checkpoint.Slices[i] = &exportSliceInfo{}
+		}
+		if ex.checkpoint.Format == "csv" {
+			if err := ex.writeHeader(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		if _, ok := <-sigs; ok {
+			fmt.Fprintln(progressOut, "\nstopping after the current batches...")
+			atomic.StoreInt32(&ex.stopped, 1)
+		}
+	}()
+
+	stop := make(chan struct{})
+	progressDone := make(chan struct{})
+	go func() {
+		defer close(progressDone)
+		ticker := time.NewTicker(context.Duration("interval"))
+		defer ticker.Stop()
+		last, lastTime := ex.docs, time.Now()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				ex.mu.Lock()
+				docs, total := ex.docs, ex.checkpoint.Total
+				ex.mu.Unlock()
+				printExportProgress(progressOut, docs, total, float64(docs-last)/now.Sub(lastTime).Seconds())
+				last, lastTime = docs, now
+			}
+		}
+	}()
+
+	start, startDocs := time.Now(), ex.docs
+	var wg sync.WaitGroup
+	errs := make([]error, len(ex.checkpoint.Slices))
+	for i := range ex.checkpoint.Slices {
+		if ex.checkpoint.Slices[i].Done {
+			continue
+		}
+		wg.Add(1)
+		go func(slice int) {
+			defer wg.Done()
+			errs[slice] = ex.exportSlice(client, ctx, context, slice)
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	<-progressDone
+
+	docs := ex.docs
+	printExportProgress(progressOut, docs, ex.checkpoint.Total, float64(docs-startDocs)/time.Since(start).Seconds())
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	if atomic.LoadInt32(&ex.stopped) == 1 {
+		ex.checkpoint.Stopped = true
+		if err := ex.saveCheckpoint(); err != nil {
+			return err
+		}
+		return fmt.Errorf("export stopped, continue with --resume in %s", context.String("keep-alive"))
+	}
+
+	if ex.checkpointName != "" {
+		os.Remove(ex.checkpointName)
+		fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] %d documents of %s exported to %s.", docs, index, context.String("out"))))
+	}
+	return nil
+}
+
+// exportSlice scroll the documents of slice and write them until the scroll is done or stopped.
+func (ex *dataExporter) exportSlice(client *elastic.Client, ctx ctx.Context, context *cli.Context, slice int) error {
+	info := ex.checkpoint.Slices[slice]
+	scroll := client.Scroll(ex.checkpoint.Index).
+		Size(context.Int("size")).
+		KeepAlive(context.String("keep-alive"))
+	if info.ScrollID != "" {
+		scroll = scroll.ScrollId(info.ScrollID)
+	} else {
+		if ex.checkpoint.Query != "" {
+			scroll = scroll.Query(elastic.NewRawStringQuery(ex.checkpoint.Query))
+		}
+		if len(ex.fields) > 0 {
+			scroll = scroll.FetchSourceContext(elastic.NewFetchSourceContext(true).Include(ex.fields...))
+		}
+		if len(ex.checkpoint.Slices) > 1 {
+			scroll = scroll.Slice(elastic.NewSliceQuery().Id(slice).Max(len(ex.checkpoint.Slices)))
+		}
+	}
+
+	first := info.ScrollID == ""
+	for atomic.LoadInt32(&ex.stopped) == 0 {
+		res, err := scroll.Do(ctx)
+		if err == io.EOF {
+			ex.mu.Lock()
+			info.Done = true
+			err = ex.saveCheckpoint()
+			ex.mu.Unlock()
+			scroll.Clear(ctx)
+			return err
+		} else if elastic.IsNotFound(err) {
+			return fmt.Errorf("the scroll of slice %d expired, export again without --resume", slice)
+		} else if err != nil {
+			return err
+		}
+
+		if first {
+			ex.mu.Lock()
+			ex.checkpoint.Total += res.Hits.TotalHits
+			ex.mu.Unlock()
+			first = false
+		}
+		if err := ex.write(slice, res.ScrollId, res.Hits.Hits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadExportCheckpoint load the checkpoint, which must be the export of the same index and format.
+func loadExportCheckpoint(name, index string, context *cli.Context) (*exportCheckpoint, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := new(exportCheckpoint)
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %s", name, err)
+	}
+	if checkpoint.Index != index || checkpoint.Format != context.String("format") || checkpoint.Fields != context.String("fields") ||
+		checkpoint.Query != context.String("query") || checkpoint.Meta != context.Bool("meta") ||
+		len(checkpoint.Slices) != context.Int("slices") {
+		return nil, fmt.Errorf("checkpoint %s is the export of %s (format: %s, fields: %s, query: %s, meta: %t, slices: %d), resume with the same arguments",
+			name, checkpoint.Index, checkpoint.Format, checkpoint.Fields, checkpoint.Query, checkpoint.Meta, len(checkpoint.Slices))
+	}
+	return checkpoint, nil
+}
+
+// printExportProgress print the exported documents, the rate and the eta.
+func printExportProgress(w io.Writer, docs, total int64, rate float64) {
+	progress := fmt.Sprintf("exported: %d, total: %d", docs, total)
+	if total > 0 {
+		progress += fmt.Sprintf(" (%.1f%%)", float64(docs)*100/float64(total))
+	}
+	progress += fmt.Sprintf(", rate: %.0f docs/s", rate)
+	if rate > 0 && total > docs {
+		progress += fmt.Sprintf(", eta: %s", time.Duration(float64(total-docs)/rate)*time.Second)
+	}
+	fmt.Fprintln(w, progress)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"text/tabwriter"
@@ -24,6 +25,10 @@ func (d *Display) Flush() error {
 
 // NewTableDisplay creates a display instance, and uses to format output with table.
 func NewTableDisplay() *Display {
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-	return &Display{w}
+	return NewTableDisplayWriter(os.Stdout)
+}
+
+// NewTableDisplayWriter creates a display instance which output the table to w.
+func NewTableDisplayWriter(w io.Writer) *Display {
+	return &Display{tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)}
 }
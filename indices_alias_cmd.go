@@ -0,0 +1,230 @@
+package main
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+// aliasAddFlags is shared by alias add and swap commands.
+var aliasAddFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "filter",
+		Value: "",
+		Usage: "set the filter query json of alias, ex: '{\"term\":{\"user\":\"kimchy\"}}'.",
+	},
+	cli.StringFlag{
+		Name:  "routing",
+		Value: "",
+		Usage: "set both the index and search routing of alias.",
+	},
+	cli.StringFlag{
+		Name:  "index-routing",
+		Value: "",
+		Usage: "set the index routing of alias.",
+	},
+	cli.StringFlag{
+		Name:  "search-routing",
+		Value: "",
+		Usage: "set the search routing of alias, ex: 1,2.",
+	},
+	cli.BoolFlag{
+		Name:  "is-write-index",
+		Usage: "set the index as the write index of alias.",
+	},
+}
+
+// alias list
+var indicesAliasListCommand = cli.Command{
+	Name:        "list",
+	Aliases:     []string{"l"},
+	Usage:       "cat indices alias list from elastic cluster.",
+	ArgsUsage:   `[--alias "alias* or alias1,alias2"]`,
+	Description: `Display the aliases of elastic cluster.`,
+	Flags:       aliasListFlags,
+	Action: func(context *cli.Context) error {
+		return indicesCatAliasCmd(context)
+	},
+}
+
+// alias add                alias index [index...]
+var indicesAliasAddCommand = cli.Command{
+	Name:      "add",
+	Usage:     "Add the indices to an alias.",
+	ArgsUsage: `alias index [index...] [--filter json] [--routing r] [--is-write-index]`,
+	Description: `Add the indices to the alias with the filter and routing, the existing alias of
+   the indices is replaced.`,
+	Flags: aliasAddFlags,
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 2 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "add")
+			logrus.Fatalf("Must provide alias and index for alias add command!")
+		}
+		action, err := newAliasAddAction(context, context.Args().Get(0), context.Args()[1:])
+		if err != nil {
+			return err
+		}
+
+		return indicesAliasUpdateCmd(context, context.Args().Get(0), action)
+	},
+}
+
+// alias remove             alias index [index...]
+var indicesAliasRemoveCommand = cli.Command{
+	Name:        "remove",
+	Aliases:     []string{"rm"},
+	Usage:       "Remove the indices from an alias.",
+	ArgsUsage:   `alias index [index...]`,
+	Description: `Remove the indices from the alias, the index can be a pattern, ex: logs-*.`,
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 2 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "remove")
+			logrus.Fatalf("Must provide alias and index for alias remove command!")
+		}
+		alias := context.Args().Get(0)
+
+		return indicesAliasUpdateCmd(context, alias, elastic.NewAliasRemoveAction(alias).Index(context.Args()[1:]...))
+	},
+}
+
+// alias swap               alias --to index [--from index]
+var indicesAliasSwapCommand = cli.Command{
+	Name:      "swap",
+	Usage:     "Move an alias from some indices to the others atomically.",
+	ArgsUsage: `alias --to c,d [--from a,b]`,
+	Description: `Remove the alias from the --from indices (default all the current indices of alias) and
+   add it to the --to indices in a single aliases request, so the alias is never missing or pointing to both.`,
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "from",
+			Value: "",
+			Usage: "set the indices to remove the alias from, default all the current indices of alias.",
+		},
+		cli.StringFlag{
+			Name:  "to",
+			Value: "",
+			Usage: "set the indices to add the alias to.",
+		},
+	}, aliasAddFlags...),
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 || context.String("to") == "" {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "swap")
+			logrus.Fatalf("Must provide alias and --to index for alias swap command!")
+		}
+
+		return indicesAliasSwapCmd(context)
+	},
+}
+
+// newAliasAddAction create the add action of alias with the filter and routing flags.
+func newAliasAddAction(context *cli.Context, alias string, indices []string) (*elastic.AliasAddAction, error) {
+	action := elastic.NewAliasAddAction(alias).Index(indices...)
+	if filter := context.String("filter"); filter != "" {
+		if !isJSON(filter) {
+			return nil, fmt.Errorf("invalid filter json %s", filter)
+		}
+		action = action.Filter(elastic.NewRawStringQuery(filter))
+	}
+	if routing := context.String("routing"); routing != "" {
+		action = action.Routing(routing)
+	}
+	if routing := context.String("index-routing"); routing != "" {
+		action = action.IndexRouting(routing)
+	}
+	if routing := context.String("search-routing"); routing != "" {
+		action = action.SearchRouting(routing)
+	}
+	if context.Bool("is-write-index") {
+		if len(indices) != 1 {
+			return nil, errors.New("only one index can be the write index of alias")
+		}
+		action = action.IsWriteIndex(true)
+	}
+	return action, nil
+}
+
+func indicesAliasSwapCmd(context *cli.Context) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	alias := context.Args().Get(0)
+	var from []string
+	if context.String("from") != "" {
+		from = strings.Split(context.String("from"), ",")
+	} else {
+		res, err := client.CatAliasService().Alias(alias).Do(ctx)
+		if err != nil {
+			return err
+		}
+		for _, aliasInfo := range res.Aliases {
+			from = append(from, aliasInfo.Index)
+		}
+		if len(from) == 0 {
+			return fmt.Errorf("alias %s not found, use 'indices alias add' instead", alias)
+		}
+	}
+
+	add, err := newAliasAddAction(context, alias, strings.Split(context.String("to"), ","))
+	if err != nil {
+		return err
+	}
+
+	return updateAliases(client, ctx, alias, elastic.NewAliasRemoveAction(alias).Index(from...), add)
+}
+
+func indicesAliasUpdateCmd(context *cli.Context, alias string, actions ...elastic.AliasAction) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	return updateAliases(client, ctx, alias, actions...)
+}
+
+// updateAliases apply the actions in a single aliases request, and print the alias before and after.
+func updateAliases(client *elastic.Client, ctx ctx.Context, alias string, actions ...elastic.AliasAction) error {
+	before, err := client.CatAliasService().Alias(alias).Do(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Println("before:")
+	printAliasesList(before)
+
+	res, err := client.Alias().Action(actions...).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if !res.Acknowledged {
+		return fmt.Errorf("update alias %s not acknowledged", alias)
+	}
+
+	after, err := client.CatAliasService().Alias(alias).Do(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Println("\nafter:")
+	printAliasesList(after)
+
+	fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] alias %s updated.", alias)))
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+// byQueryFlags is shared by delete-by-query and update-by-query commands.
+var byQueryFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "query, q",
+		Value: "",
+		Usage: "set the query json, ex: '{\"term\":{\"user\":\"kimchy\"}}'.",
+	},
+	cli.StringFlag{
+		Name:  "lucene, l",
+		Value: "",
+		Usage: "set the query with lucene query string, ex: 'user:kimchy AND age:>30'.",
+	},
+	cli.BoolFlag{
+		Name:  "conflicts-proceed",
+		Usage: "count the version conflicts and go on, default abort on version conflicts.",
+	},
+	cli.StringFlag{
+		Name:  "slices",
+		Value: "1",
+		Usage: "set the number of slices to run in parallel, or 'auto'.",
+	},
+	cli.IntFlag{
+		Name:  "requests-per-second",
+		Value: -1,
+		Usage: "set the throttle in documents per second, -1 means no throttle.",
+	},
+	cli.DurationFlag{
+		Name:  "interval, i",
+		Value: 5 * time.Second,
+		Usage: "set the interval to print the progress of task.",
+	},
+	cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "only count the documents matched the query.",
+	},
+	cli.BoolFlag{
+		Name:  "yes, y",
+		Usage: "Answer the documents conform.",
+	},
+}
+
+// delete-by-query
+var indicesDeleteByQueryCommand = cli.Command{
+	Name:      "delete-by-query",
+	Usage:     "Delete the documents matched the query.",
+	ArgsUsage: `index [-q json | -l lucene]`,
+	Description: `Count the documents matched the query and delete them after conform, the delete runs as
+   a task and the progress is printed until it's done, Ctrl-C cancels the task.`,
+	Flags: byQueryFlags,
+	Action: func(context *cli.Context) error {
+		if err := checkByQueryArgs(context, "delete-by-query"); err != nil {
+			return err
+		}
+		if context.String("query") == "" && context.String("lucene") == "" {
+			return errors.New("indices delete-by-query must provide --query or --lucene, use '{\"match_all\":{}}' to delete all")
+		}
+
+		return indicesByQueryCmd(context, "delete")
+	},
+}
+
+// update-by-query
+var indicesUpdateByQueryCommand = cli.Command{
+	Name:      "update-by-query",
+	Usage:     "Update the documents matched the query in place.",
+	ArgsUsage: `index [-q json | -l lucene] [--script 'ctx._source.count++'] [--pipeline name]`,
+	Description: `Count the documents matched the query (all documents without query) and update them after
+   conform, the documents are reindexed with the script or the pipeline, or only reindexed to pick up
+   the new mappings. The update runs as a task and the progress is printed until it's done, Ctrl-C
+   cancels the task.`,
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "script",
+			Value: "",
+			Usage: "set the painless script to update the document.",
+		},
+		cli.StringFlag{
+			Name:  "pipeline",
+			Value: "",
+			Usage: "set the ingest pipeline to process the document.",
+		},
+	}, byQueryFlags...),
+	Action: func(context *cli.Context) error {
+		if err := checkByQueryArgs(context, "update-by-query"); err != nil {
+			return err
+		}
+
+		return indicesByQueryCmd(context, "update")
+	},
+}
+
+func checkByQueryArgs(context *cli.Context, name string) error {
+	if context.NArg() != 1 {
+		fmt.Printf("Incorrect Usage.\n\n")
+		cli.ShowCommandHelp(context, name)
+		logrus.Fatalf("Must provide index for %s command!", name)
+	}
+	if context.String("query") != "" && context.String("lucene") != "" {
+		return fmt.Errorf("indices %s must not provide both --query and --lucene", name)
+	}
+	if query := context.String("query"); query != "" && !isJSON(query) {
+		return fmt.Errorf("invalid query json %s", query)
+	}
+	return nil
+}
+
+func indicesByQueryCmd(context *cli.Context, action string) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	index := context.Args().Get(0)
+	var query elastic.Query
+	if context.String("query") != "" {
+		query = elastic.NewRawStringQuery(context.String("query"))
+	}
+
+	count := client.Count(index)
+	if query != nil {
+		count = count.Query(query)
+	} else if context.String("lucene") != "" {
+		count = count.Q(context.String("lucene"))
+	}
+	total, err := count.Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d documents of %s matched the query.\n", total, index)
+	if total == 0 {
+		fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] there is no document to %s.", action)))
+		return nil
+	}
+	if context.Bool("dry-run") {
+		return nil
+	}
+
+	fmt.Println(sgrBoldBlue(fmt.Sprintf("[Attention] %s above documents? type (yes) to conform %s.", strings.Title(action), action)))
+	if !context.Bool("yes") {
+		YesOrDie(fmt.Sprintf("%s %d documents of %s", action, total, index))
+	}
+
+	var taskID string
+	switch action {
+	case "delete":
+		taskID, err = startDeleteByQuery(client, ctx, context, index, query)
+	case "update":
+		taskID, err = startUpdateByQuery(client, ctx, context, index, query)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s by query of %s started, task: %s\n", action, index, taskID)
+
+	res, err := followTask(client, ctx, taskID, context.Duration("interval"))
+	if err != nil {
+		return err
+	}
+	printTaskResponse(res)
+	if len(res.Failures) > 0 {
+		return fmt.Errorf("%s by query failed on %d documents", action, len(res.Failures))
+	}
+	if res.Canceled != "" {
+		return fmt.Errorf("%s by query canceled: %s", action, res.Canceled)
+	}
+
+	fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] %s by query done.", action)))
+	return nil
+}
+
+// startDeleteByQuery start the delete by query task and return the task id.
+func startDeleteByQuery(client *elastic.Client, ctx ctx.Context, context *cli.Context, index string, query elastic.Query) (string, error) {
+	service := client.DeleteByQuery(index).RequestsPerSecond(context.Int("requests-per-second"))
+	if query != nil {
+		service = service.Query(query)
+	} else {
+		service = service.Q(context.String("lucene"))
+	}
+	if context.Bool("conflicts-proceed") {
+		service = service.ProceedOnVersionConflict()
+	}
+	if slices := context.String("slices"); slices != "1" {
+		service = service.Slices(slices)
+	}
+
+	res, err := service.DoAsync(ctx)
+	if err != nil {
+		return "", err
+	}
+	return res.TaskId, nil
+}
+
+// startUpdateByQuery start the update by query task and return the task id.
+func startUpdateByQuery(client *elastic.Client, ctx ctx.Context, context *cli.Context, index string, query elastic.Query) (string, error) {
+	service := client.UpdateByQuery(index).RequestsPerSecond(context.Int("requests-per-second"))
+	if query != nil {
+		service = service.Query(query)
+	} else if context.String("lucene") != "" {
+		service = service.Q(context.String("lucene"))
+	}
+	if script := context.String("script"); script != "" {
+		service = service.Script(elastic.NewScript(script))
+	}
+	if pipeline := context.String("pipeline"); pipeline != "" {
+		service = service.Pipeline(pipeline)
+	}
+	if context.Bool("conflicts-proceed") {
+		service = service.ProceedOnVersionConflict()
+	}
+	if slices := context.String("slices"); slices != "1" {
+		service = service.Slices(slices)
+	}
+
+	res, err := service.DoAsync(ctx)
+	if err != nil {
+		return "", err
+	}
+	return res.TaskId, nil
+}
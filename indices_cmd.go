@@ -24,14 +24,34 @@ var indicesCommand = cli.Command{
 		indicesListCommand,
 		// indices cat shards
 		indicesCatShardsCommand,
+		// indices create
+		indicesCreateCommand,
 		// indices open
 		indicesOpenCommand,
 		// indices close
 		indicesCloseCommand,
 		// indices delete
 		indicesDeleteCommand,
+		// indices prune
+		indicesPruneCommand,
+		// indices rollover
+		indicesRolloverCommand,
+		// indices forcemerge
+		indicesForcemergeCommand,
+		// indices shrink
+		indicesShrinkCommand,
+		// indices reindex
+		indicesReindexCommand,
+		// indices delete-by-query
+		indicesDeleteByQueryCommand,
+		// indices update-by-query
+		indicesUpdateByQueryCommand,
 		// indices settings
 		indicesSettingsCommand,
+		// indices mapping
+		indicesMappingCommand,
+		// indices fields
+		indicesFieldsCommand,
 		// indices template
 		indicesTemplateCommand,
 		// indices cat aliases
@@ -39,23 +59,36 @@ var indicesCommand = cli.Command{
 	},
 }
 
+// aliasListFlags is shared by alias and alias list commands.
+var aliasListFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "format",
+		Value: "text",
+		Usage: "set the format of output('text' (default),or 'json')",
+	},
+	cli.StringFlag{
+		Name:  "alias",
+		Value: "",
+		Usage: "set alias for query(alias1,alias2).",
+	},
+}
+
 //cat alias
 var indicesCatAliasesCommand = cli.Command{
 	Name:        "alias",
-	Usage:       "cat indices alias list from elastic cluster.",
+	Usage:       "cat indices alias list from elastic cluster, or add, remove and swap alias.",
 	ArgsUsage:   `[-i "alias* or alias1,alias2"]`,
 	Description: `Display the cat indices of elastic cluster.`,
-	Flags: []cli.Flag{
-		cli.StringFlag{
-			Name:  "format",
-			Value: "text",
-			Usage: "set the format of output('text' (default),or 'json')",
-		},
-		cli.StringFlag{
-			Name:  "alias",
-			Value: "",
-			Usage: "set alias for query(alias1,alias2).",
-		},
+	Flags:       aliasListFlags,
+	Subcommands: []cli.Command{
+		// indices alias list
+		indicesAliasListCommand,
+		// indices alias add
+		indicesAliasAddCommand,
+		// indices alias remove
+		indicesAliasRemoveCommand,
+		// indices alias swap
+		indicesAliasSwapCommand,
 	},
 	Action: func(context *cli.Context) error {
 		return indicesCatAliasCmd(context)
@@ -603,43 +636,66 @@ func indicesSettingsCmd(context *cli.Context) error {
 	return nil
 }
 
+// indicesTemplateFlags is the flags to get or set the templates.
+var indicesTemplateFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "get, g",
+		Usage: "get the template of templates(tpl1,tpl2).",
+	},
+	cli.StringFlag{
+		Name:  "set, s",
+		Value: "",
+		Usage: "set the template of templates(tpl1,tpl2): -s '{settings_json}'.",
+	},
+	cli.StringFlag{
+		Name:  "replicas, r",
+		Value: "",
+		Usage: "set the number_of_replicas of template(tpl1,tpl2): -r num.",
+	},
+	cli.StringFlag{
+		Name:  "shards",
+		Value: "",
+		Usage: "set the number_of_shards of template(tpl1,tpl2): --shards num.",
+	},
+}
+
 // template
 var indicesTemplateCommand = cli.Command{
 	Name:        "template",
-	Usage:       "Get template of the elasticsearch indices.",
+	Usage:       "Get or set template of the elasticsearch indices, or list, put, delete, export and sync templates.",
 	Aliases:     []string{"tpl"},
 	ArgsUsage:   `tpl1,tpl2`,
-	Description: `The command get template of the elasticsearch indices.`,
-	Flags: []cli.Flag{
-		cli.BoolFlag{
-			Name:  "get, g",
-			Usage: "get the template of templates(tpl1,tpl2).",
-		},
-		cli.StringFlag{
-			Name:  "set, s",
-			Value: "",
-			Usage: "set the template of templates(tpl1,tpl2): -s '{settings_json}'.",
-		},
-		cli.StringFlag{
-			Name:  "replicas, r",
-			Value: "",
-			Usage: "set the number_of_replicas of template(tpl1,tpl2): -r num.",
-		},
+	Description: `The command get or set template of the elasticsearch indices.`,
+	Subcommands: []cli.Command{
+		// indices template list
+		indicesTemplateListCommand,
+		// indices template put
+		indicesTemplatePutCommand,
+		// indices template delete
+		indicesTemplateDeleteCommand,
+		// indices template export
+		indicesTemplateExportCommand,
+		// indices template sync
+		indicesTemplateSyncCommand,
 	},
+	Flags: indicesTemplateFlags,
 	Action: func(context *cli.Context) error {
-		if context.NArg() != 1 {
+		args, err := parseTrailingFlags(context, indicesTemplateFlags)
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
 			fmt.Printf("Incorrect Usage.\n\n")
-			cli.ShowCommandHelp(context, "template")
+			cli.ShowAppHelp(context)
 			logrus.Fatalf("Must provide templateName for template command!")
 		}
 
-		return indicesTemplateCmd(context)
+		return indicesTemplateCmd(context, args[0])
 	},
 }
 
-func indicesTemplateCmd(context *cli.Context) error {
-	var templatesName string
-	if templatesName = context.Args().Get(0); templatesName == "" {
+func indicesTemplateCmd(context *cli.Context, templatesName string) error {
+	if templatesName == "" {
 		return errors.New("please check templatesName for template command")
 	}
 
@@ -685,47 +741,28 @@ func indicesTemplateCmd(context *cli.Context) error {
 		}
 		fmt.Println(jsonPrettyPrint(string(jsonStr)))
 
-	} else if str := context.String("replicas"); str != "" {
-		if num, err := strconv.Atoi(str); err != nil || num < 0 {
-			return fmt.Errorf("Invalid replicas num: %s", str)
+	} else if context.String("replicas") != "" || context.String("shards") != "" {
+		settings := make(map[string]interface{})
+		if str := context.String("replicas"); str != "" {
+			if num, err := strconv.Atoi(str); err != nil || num < 0 {
+				return fmt.Errorf("Invalid replicas num: %s", str)
+			}
+			settings["index.number_of_replicas"] = str
 		}
-
-		indexGetTemplate := client.IndexGetTemplate(templatesList[0])
-
-		res, err := indexGetTemplate.FlatSettings(true).Do(ctx)
-		if err != nil {
-			return err
+		if str := context.String("shards"); str != "" {
+			if num, err := strconv.Atoi(str); err != nil || num < 1 {
+				return fmt.Errorf("Invalid shards num: %s", str)
+			}
+			settings["index.number_of_shards"] = str
 		}
-		jsonStr, err := json.Marshal(res)
-		if err != nil {
+
+		if err := updateTemplatesSettings(client, ctx, templatesList, settings); err != nil {
 			return err
 		}
 
-		out := map[string]interface{}{}
-		json.Unmarshal([]byte(jsonStr), &out)
-
-		fmt.Println(out)
-		// jsonStr := fmt.Sprintf("{\"index.number_of_replicas\": \"%s\"}", str)
-
-		// if !isJSON(jsonStr) {
-		// 	return fmt.Errorf("'%s' is not a json string", jsonStr)
-		// }
-
-		// indexPutTemplate := client.IndexPutTemplate(templatesList[0])
-		// res, err := indexPutTemplate.BodyJson(jsonStr).Do(ctx)
-		// if err != nil {
-		// 	return err
-		// }
-
-		// jsonRes, err := json.Marshal(res)
-		// if err != nil {
-		// 	return err
-		// }
-		// fmt.Println(jsonPrettyPrint(string(jsonRes)))
-
 	} else {
-		cli.ShowCommandHelp(context, "template")
-		return fmt.Errorf("indices template must provide -g or -s parameters")
+		cli.ShowAppHelp(context)
+		return fmt.Errorf("indices template must provide -g, -s, -r or --shards parameters")
 	}
 
 	return nil
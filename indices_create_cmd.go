@@ -0,0 +1,316 @@
+package main
+
+import (
+	ctx "context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+// create
+var indicesCreateCommand = cli.Command{
+	Name:      "create",
+	Usage:     "Create an index with the settings, mappings and aliases.",
+	ArgsUsage: `name [-f body.json|-] [--from-template] [--wait-for-active-shards all]`,
+	Description: `Create the index with the body of file (or stdin with '-'), the body is an object with
+   settings, mappings and aliases. With --from-template, preview the index templates matched the name
+   and the settings, mappings and aliases the new index would have.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file, f",
+			Value: "",
+			Usage: "set the body file of index, '-' for stdin.",
+		},
+		cli.BoolFlag{
+			Name:  "from-template",
+			Usage: "preview what the matched index templates would apply to the index.",
+		},
+		cli.StringFlag{
+			Name:  "wait-for-active-shards",
+			Value: "",
+			Usage: "set the number of shard copies must be active before return, ex: 1 (default), all.",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only validate the body and preview, the index is not created.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "create")
+			logrus.Fatalf("Must provide index name for create command!")
+		}
+
+		return indicesCreateCmd(context)
+	},
+}
+
+// indexBody is the body of create index.
+type indexBody struct {
+	Settings map[string]interface{} `json:"settings,omitempty"`
+	Mappings map[string]interface{} `json:"mappings,omitempty"`
+	Aliases  map[string]interface{} `json:"aliases,omitempty"`
+}
+
+func indicesCreateCmd(context *cli.Context) error {
+	name := context.Args().Get(0)
+	if err := validateIndexName(name); err != nil {
+		return err
+	}
+
+	body := &indexBody{}
+	if fileName := context.String("file"); fileName != "" {
+		data, err := readFileOrStdin(fileName)
+		if err != nil {
+			return err
+		}
+		if body, err = parseIndexBody(data); err != nil {
+			return fmt.Errorf("invalid body of %s: %s", fileName, err)
+		}
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	if context.Bool("from-template") {
+		if err := previewIndexTemplates(client, ctx, name, body); err != nil {
+			return err
+		}
+	}
+	if context.Bool("dry-run") {
+		fmt.Println(sgrBoldBlue("[OK] dry run, the index is not created."))
+		return nil
+	}
+
+	service := client.CreateIndex(name).BodyJson(body)
+	if shards := context.String("wait-for-active-shards"); shards != "" {
+		service = service.WaitForActiveShards(shards)
+	}
+	res, err := service.Do(ctx)
+	if err != nil {
+		return err
+	}
+	if !res.ShardsAcknowledged {
+		fmt.Println(sgrBoldRed(fmt.Sprintf("[WARN] index %s created, but the shards not active before timeout.", name)))
+		return nil
+	}
+
+	fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] index %s created.", name)))
+	return nil
+}
+
+// validateIndexName check the name with the rules of elasticsearch.
+func validateIndexName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("invalid index name %q", name)
+	}
+	if name != strings.ToLower(name) {
+		return fmt.Errorf("invalid index name %s, must be lowercase", name)
+	}
+	if strings.IndexAny(name, "-_+") == 0 {
+		return fmt.Errorf("invalid index name %s, must not start with '-', '_' or '+'", name)
+	}
+	if strings.ContainsAny(name, "\\/*?\"<>| ,#:") {
+		return fmt.Errorf("invalid index name %s, must not contain \\ / * ? \" < > | space , # :", name)
+	}
+	if len(name) > 255 {
+		return fmt.Errorf("invalid index name %s, must not be longer than 255 bytes", name)
+	}
+	return nil
+}
+
+// parseIndexBody parse the body with only settings, mappings and aliases objects.
+func parseIndexBody(data []byte) (*indexBody, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	for key := range raw {
+		switch key {
+		case "settings", "mappings", "aliases":
+		default:
+			return nil, fmt.Errorf("unknown key %q, only settings, mappings and aliases are allowed", key)
+		}
+	}
+
+	body := &indexBody{}
+	for key, value := range map[string]*map[string]interface{}{
+		"settings": &body.Settings,
+		"mappings": &body.Mappings,
+		"aliases":  &body.Aliases,
+	} {
+		if data, ok := raw[key]; ok {
+			if err := json.Unmarshal(data, value); err != nil {
+				return nil, fmt.Errorf("%s must be an object", key)
+			}
+		}
+	}
+
+	settings := flattenIndexSettings(body.Settings)
+	for _, key := range []string{"index.number_of_shards", "index.number_of_replicas"} {
+		if value, ok := settings[key]; ok {
+			if num, err := strconv.Atoi(fmt.Sprintf("%v", value)); err != nil || num < 0 {
+				return nil, fmt.Errorf("invalid %s %v", key, value)
+			}
+		}
+	}
+	return body, nil
+}
+
+// flattenIndexSettings flatten the nested settings to the "index." prefixed keys,
+// ex: {"number_of_shards": 1} and {"index": {"number_of_shards": 1}} are "index.number_of_shards".
+func flattenIndexSettings(settings map[string]interface{}) map[string]interface{} {
+	flat := make(map[string]interface{})
+	var flatten func(prefix string, settings map[string]interface{})
+	flatten = func(prefix string, settings map[string]interface{}) {
+		for key, value := range settings {
+			if nested, ok := value.(map[string]interface{}); ok {
+				flatten(prefix+key+".", nested)
+				continue
+			}
+			key = prefix + key
+			if !strings.HasPrefix(key, "index.") {
+				key = "index." + key
+			}
+			flat[key] = value
+		}
+	}
+	flatten("", settings)
+	return flat
+}
+
+// mergeMaps merge the src map into dst deeply, the value of src wins.
+func mergeMaps(dst, src map[string]interface{}) map[string]interface{} {
+	if dst == nil {
+		dst = make(map[string]interface{})
+	}
+	for key, value := range src {
+		srcMap, srcOk := value.(map[string]interface{})
+		dstMap, dstOk := dst[key].(map[string]interface{})
+		if srcOk && dstOk {
+			dst[key] = mergeMaps(dstMap, srcMap)
+		} else {
+			dst[key] = value
+		}
+	}
+	return dst
+}
+
+// matchSimplePattern match the name with pattern, the '*' matches any string.
+func matchSimplePattern(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return strings.HasSuffix(name, parts[len(parts)-1])
+}
+
+// indexTemplate is the index template with name.
+type indexTemplate struct {
+	name string
+	*elastic.IndicesGetTemplateResponse
+}
+
+// patterns is the index patterns of template, the template field is used before 6.0.
+func (t *indexTemplate) patterns() []string {
+	if len(t.IndexPatterns) > 0 {
+		return t.IndexPatterns
+	}
+	if t.Template != "" {
+		return []string{t.Template}
+	}
+	return nil
+}
+
+// indexTemplates sort the templates by order, the higher order template is applied later.
+type indexTemplates []*indexTemplate
+
+func (t indexTemplates) Len() int      { return len(t) }
+func (t indexTemplates) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t indexTemplates) Less(i, j int) bool {
+	if t[i].Order != t[j].Order {
+		return t[i].Order < t[j].Order
+	}
+	return t[i].name < t[j].name
+}
+
+// getIndexTemplates get all the index templates sorted by order.
+func getIndexTemplates(client *elastic.Client, ctx ctx.Context) ([]*indexTemplate, error) {
+	res, err := client.IndexGetTemplate().FlatSettings(true).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []*indexTemplate
+	for name, template := range res {
+		templates = append(templates, &indexTemplate{name: name, IndicesGetTemplateResponse: template})
+	}
+	sort.Sort(indexTemplates(templates))
+	return templates, nil
+}
+
+// previewIndexTemplates print the templates matched the name, and the body merged with the templates.
+func previewIndexTemplates(client *elastic.Client, ctx ctx.Context, name string, body *indexBody) error {
+	templates, err := getIndexTemplates(client, ctx)
+	if err != nil {
+		return err
+	}
+
+	merged := &indexBody{}
+	display := NewTableDisplay()
+	display.AddRow([]string{"template", "index_patterns", "order"})
+	for _, template := range templates {
+		matched := false
+		for _, pattern := range template.patterns() {
+			if matchSimplePattern(pattern, name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		display.AddRow([]string{template.name, strings.Join(template.patterns(), ","), strconv.Itoa(template.Order)})
+		merged.Settings = mergeMaps(merged.Settings, flattenIndexSettings(template.Settings))
+		merged.Mappings = mergeMaps(merged.Mappings, template.Mappings)
+		merged.Aliases = mergeMaps(merged.Aliases, template.Aliases)
+	}
+	display.Flush()
+
+	merged.Settings = mergeMaps(merged.Settings, flattenIndexSettings(body.Settings))
+	merged.Mappings = mergeMaps(merged.Mappings, body.Mappings)
+	merged.Aliases = mergeMaps(merged.Aliases, body.Aliases)
+
+	jsonStr, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nindex %s would be created with:\n%s\n", name, jsonPrettyPrint(string(jsonStr)))
+	return nil
+}
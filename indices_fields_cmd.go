@@ -0,0 +1,250 @@
+package main
+
+import (
+	ctx "context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+// defaultTotalFieldsLimit is the default of index.mapping.total_fields.limit.
+const defaultTotalFieldsLimit = 1000
+
+// fields
+var indicesFieldsCommand = cli.Command{
+	Name:      "fields",
+	Usage:     "Report the field count, conflicting types and field prefixes of indices.",
+	ArgsUsage: `[--pattern 'logs-*'] [--top 10] [--format json]`,
+	Description: `Report the number of fields of each index matched the pattern against its
+   index.mapping.total_fields.limit, the fields whose type differs across the indices, and the
+   field prefixes with the most fields, which are the usual source of the mapping explosion.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "pattern, p",
+			Value: "*",
+			Usage: "set the index pattern, ex: logs-*.",
+		},
+		cli.IntFlag{
+			Name:  "top, n",
+			Value: 10,
+			Usage: "set the number of field prefixes to display.",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "set the format of output('text' (default), or 'json').",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return indicesFieldsCmd(context)
+	},
+}
+
+// indexFieldsCount is the number of fields of index.
+type indexFieldsCount struct {
+	Index  string `json:"index"`
+	Fields int    `json:"fields"`
+	Limit  int    `json:"limit"`
+}
+
+// fieldTypeConflict is the field with different types across the indices.
+type fieldTypeConflict struct {
+	Field string              `json:"field"`
+	Types map[string][]string `json:"types"`
+}
+
+// fieldPrefix is the prefix of fields, ex: the prefix of a.b.c is a and a.b.
+type fieldPrefix struct {
+	Prefix   string `json:"prefix"`
+	Fields   int    `json:"fields"`
+	MaxDepth int    `json:"max_depth"`
+}
+
+// fieldsReport is the report of fields of the indices.
+type fieldsReport struct {
+	Indices   []*indexFieldsCount  `json:"indices"`
+	Conflicts []*fieldTypeConflict `json:"conflicts"`
+	Prefixes  []*fieldPrefix       `json:"prefixes"`
+}
+
+// fieldPrefixes sort the prefixes by fields and depth desc.
+type fieldPrefixes []*fieldPrefix
+
+func (p fieldPrefixes) Len() int      { return len(p) }
+func (p fieldPrefixes) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p fieldPrefixes) Less(i, j int) bool {
+	if p[i].Fields != p[j].Fields {
+		return p[i].Fields > p[j].Fields
+	}
+	if p[i].MaxDepth != p[j].MaxDepth {
+		return p[i].MaxDepth > p[j].MaxDepth
+	}
+	return p[i].Prefix < p[j].Prefix
+}
+
+func indicesFieldsCmd(context *cli.Context) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	report, err := getFieldsReport(client, ctx, context.String("pattern"), context.Int("top"))
+	if err != nil {
+		return err
+	}
+
+	format := context.String("format")
+	switch format {
+	case "text":
+		printFieldsReport(report)
+	case "json":
+		jsonStr, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+		fmt.Println(jsonPrettyPrint(string(jsonStr)))
+	default:
+		return fmt.Errorf("unknown format %q", context.String("format"))
+	}
+
+	return nil
+}
+
+// getFieldsReport count the fields of indices with the field caps, the indices of a field type are
+// omitted if all the indices have the same type.
+func getFieldsReport(client *elastic.Client, ctx ctx.Context, pattern string, top int) (*fieldsReport, error) {
+	settings, err := client.IndexGetSettings(pattern).FlatSettings(true).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	caps, err := client.FieldCaps(pattern).Fields("*").Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var indices []string
+	for index := range settings {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+
+	counts := make(map[string]int)
+	prefixes := make(map[string]*fieldPrefix)
+	report := &fieldsReport{}
+	for field, types := range caps.Fields {
+		conflict := &fieldTypeConflict{Field: field, Types: make(map[string][]string)}
+		for kind, fieldCaps := range types {
+			// the metadata fields, ex: _id, _source.
+			if strings.HasPrefix(kind, "_") {
+				continue
+			}
+			fieldIndices := fieldCaps.Indices
+			if len(fieldIndices) == 0 {
+				fieldIndices = indices
+			}
+			for _, index := range fieldIndices {
+				counts[index]++
+			}
+			conflict.Types[kind] = fieldIndices
+		}
+		if len(conflict.Types) == 0 {
+			continue
+		}
+		if len(conflict.Types) > 1 {
+			report.Conflicts = append(report.Conflicts, conflict)
+		}
+
+		parts := strings.Split(field, ".")
+		for i := 1; i < len(parts); i++ {
+			prefix := strings.Join(parts[:i], ".")
+			if prefixes[prefix] == nil {
+				prefixes[prefix] = &fieldPrefix{Prefix: prefix}
+			}
+			prefixes[prefix].Fields++
+			if depth := len(parts) - i; depth > prefixes[prefix].MaxDepth {
+				prefixes[prefix].MaxDepth = depth
+			}
+		}
+	}
+
+	for _, index := range indices {
+		limit := defaultTotalFieldsLimit
+		if value, ok := settings[index].Settings["index.mapping.total_fields.limit"]; ok {
+			if num, err := strconv.Atoi(fmt.Sprintf("%v", value)); err == nil {
+				limit = num
+			}
+		}
+		report.Indices = append(report.Indices, &indexFieldsCount{Index: index, Fields: counts[index], Limit: limit})
+	}
+
+	sort.Sort(fieldTypeConflicts(report.Conflicts))
+	for _, prefix := range prefixes {
+		report.Prefixes = append(report.Prefixes, prefix)
+	}
+	sort.Sort(fieldPrefixes(report.Prefixes))
+	if top >= 0 && len(report.Prefixes) > top {
+		report.Prefixes = report.Prefixes[:top]
+	}
+	return report, nil
+}
+
+// fieldTypeConflicts sort the conflicts by field.
+type fieldTypeConflicts []*fieldTypeConflict
+
+func (c fieldTypeConflicts) Len() int           { return len(c) }
+func (c fieldTypeConflicts) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c fieldTypeConflicts) Less(i, j int) bool { return c[i].Field < c[j].Field }
+
+func printFieldsReport(report *fieldsReport) {
+	// index fields limit usage
+	display := NewTableDisplay()
+	display.AddRow([]string{"index", "fields", "limit", "usage"})
+	for _, count := range report.Indices {
+		usage := "-"
+		if count.Limit > 0 {
+			usage = fmt.Sprintf("%.1f%%", float64(count.Fields)*100/float64(count.Limit))
+		}
+		display.AddRow([]string{count.Index, strconv.Itoa(count.Fields), strconv.Itoa(count.Limit), usage})
+	}
+	display.Flush()
+
+	// field types
+	fmt.Printf("\n%d fields have conflicting types:\n", len(report.Conflicts))
+	if len(report.Conflicts) > 0 {
+		display = NewTableDisplay()
+		display.AddRow([]string{"field", "types"})
+		for _, conflict := range report.Conflicts {
+			var kinds []string
+			for kind := range conflict.Types {
+				kinds = append(kinds, kind)
+			}
+			sort.Strings(kinds)
+
+			var types []string
+			for _, kind := range kinds {
+				types = append(types, fmt.Sprintf("%s(%s)", kind, strings.Join(conflict.Types[kind], ",")))
+			}
+			display.AddRow([]string{conflict.Field, strings.Join(types, " ")})
+		}
+		display.Flush()
+	}
+
+	// prefix fields max_depth
+	fmt.Printf("\ntop %d field prefixes:\n", len(report.Prefixes))
+	display = NewTableDisplay()
+	display.AddRow([]string{"prefix", "fields", "max_depth"})
+	for _, prefix := range report.Prefixes {
+		display.AddRow([]string{prefix.Prefix, strconv.Itoa(prefix.Fields), strconv.Itoa(prefix.MaxDepth)})
+	}
+	display.Flush()
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+// forcemerge
+var indicesForcemergeCommand = cli.Command{
+	Name:      "forcemerge",
+	Aliases:   []string{"fm"},
+	Usage:     "Force merge the segments of indices one by one.",
+	ArgsUsage: `index [-n 1] [--only-expunge-deletes]`,
+	Description: `Force merge the open indices matched the pattern one by one, the merge of next index
+   starts when the previous one is done, and the progress is printed with the segments API.
+   Force merge is expensive, only merge the indices which are no longer written.`,
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "max-num-segments, n",
+			Value: 1,
+			Usage: "set the number of segments each shard is merged to.",
+		},
+		cli.BoolFlag{
+			Name:  "only-expunge-deletes",
+			Usage: "only merge the segments with deleted docs, --max-num-segments is ignored.",
+		},
+		cli.DurationFlag{
+			Name:  "interval, i",
+			Value: 10 * time.Second,
+			Usage: "set the interval to print the progress of force merge.",
+		},
+		cli.BoolFlag{
+			Name:  "yes, y",
+			Usage: "Answer force merge indices conform.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "forcemerge")
+			logrus.Fatalf("Must provide index for forcemerge command!")
+		}
+		if !context.Bool("only-expunge-deletes") && context.Int("max-num-segments") <= 0 {
+			return errors.New("indices forcemerge --max-num-segments must be greater than 0")
+		}
+
+		return indicesForcemergeCmd(context)
+	},
+}
+
+// segmentsInfo is the segments summary of all shard copies of an index.
+type segmentsInfo struct {
+	shards      int
+	segments    int64
+	deletedDocs int64
+}
+
+func indicesForcemergeCmd(context *cli.Context) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	res, err := client.CatIndicesService().Index(context.Args().Get(0)).Bytes("b").Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the closed indices can not be merged.
+	var indices []string
+	for _, indexInfo := range res.Indices {
+		if indexInfo.Status == "open" {
+			indices = append(indices, indexInfo.Index)
+		}
+	}
+	if len(indices) == 0 {
+		return fmt.Errorf("no open index matched %s", context.Args().Get(0))
+	}
+	sort.Strings(indices)
+
+	infos := make(map[string]*segmentsInfo)
+	for _, index := range indices {
+		if infos[index], err = getSegmentsInfo(client, ctx, index); err != nil {
+			return err
+		}
+	}
+	printForcemergeIndices(res, infos)
+
+	fmt.Println(sgrBoldBlue("[Attention] Force merge above indices? type (yes) to conform force merge."))
+	if !context.Bool("yes") {
+		YesOrDie(fmt.Sprintf("%d indices", len(indices)))
+	}
+
+	expunge := context.Bool("only-expunge-deletes")
+	maxNumSegments := context.Int("max-num-segments")
+	for i, index := range indices {
+		step := fmt.Sprintf("[%d/%d] %s", i+1, len(indices), index)
+		target := "expunge deletes"
+		if !expunge {
+			target = fmt.Sprintf("%d segments", infos[index].shards*maxNumSegments)
+		}
+		fmt.Printf("%s: force merge %d segments to %s.\n", step, infos[index].segments, target)
+
+		if err := forcemergeIndex(client, ctx, index, step, maxNumSegments, expunge, context.Duration("interval")); err != nil {
+			return fmt.Errorf("%s: %s", step, err)
+		}
+	}
+
+	fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] %d indices force merged.", len(indices))))
+	return nil
+}
+
+// forcemergeIndex force merge the index and print the segments until it's done,
+// the force merge request returns when the merge is done.
+func forcemergeIndex(client *elastic.Client, ctx ctx.Context, index, step string, maxNumSegments int, expunge bool,
+	interval time.Duration) error {
+	service := client.Forcemerge(index)
+	if expunge {
+		service = service.OnlyExpungeDeletes(true)
+	} else {
+		service = service.MaxNumSegments(maxNumSegments)
+	}
+
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		res, err := service.Do(ctx)
+		if err == nil && res.Shards.Failed > 0 {
+			err = fmt.Errorf("force merge failed on %d of %d shards", res.Shards.Failed, res.Shards.Total)
+		}
+		done <- err
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+			info, err := getSegmentsInfo(client, ctx, index)
+			if err != nil {
+				return err
+			}
+			fmt.Println(sgrBoldBlue(fmt.Sprintf("%s: done in %s, segments: %d, docs.deleted: %d.", step,
+				time.Since(start).Truncate(time.Second), info.segments, info.deletedDocs)))
+			return nil
+		case <-ticker.C:
+			info, err := getSegmentsInfo(client, ctx, index)
+			if err != nil {
+				logrus.Warnf("get segments of %s failed: %s", index, err)
+				continue
+			}
+			fmt.Printf("%s: merging for %s, segments: %d, docs.deleted: %d\n", step,
+				time.Since(start).Truncate(time.Second), info.segments, info.deletedDocs)
+		}
+	}
+}
+
+// getSegmentsInfo get the count of search segments and deleted docs of all shard copies.
+func getSegmentsInfo(client *elastic.Client, ctx ctx.Context, index string) (*segmentsInfo, error) {
+	res, err := client.IndexSegments(index).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	indexSegments, ok := res.Indices[index]
+	if !ok {
+		return nil, fmt.Errorf("no segments of index %s", index)
+	}
+
+	info := &segmentsInfo{}
+	for _, copies := range indexSegments.Shards {
+		for _, shard := range copies {
+			info.shards++
+			info.segments += shard.NumSearchSegments
+			for _, segment := range shard.Segments {
+				info.deletedDocs += segment.DeletedDocs
+			}
+		}
+	}
+	return info, nil
+}
+
+// index pri rep docs.count docs.deleted store.size shards segments
+func printForcemergeIndices(res *elastic.CatIndicesResponse, infos map[string]*segmentsInfo) {
+	display := NewTableDisplay()
+	display.AddRow([]string{"index", "pri", "rep", "docs.count", "docs.deleted", "store.size", "shards", "segments"})
+	for _, indexInfo := range res.Indices {
+		info, ok := infos[indexInfo.Index]
+		if !ok {
+			continue
+		}
+		size, _ := strconv.ParseInt(indexInfo.Size, 10, 64)
+		display.AddRow([]string{
+			indexInfo.Index,
+			indexInfo.Pri,
+			indexInfo.Rep,
+			indexInfo.Count,
+			indexInfo.Deleted,
+			formatBytes(size),
+			strconv.Itoa(info.shards),
+			strconv.FormatInt(info.segments, 10)})
+	}
+	display.Flush()
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+// mapping
+var indicesMappingCommand = cli.Command{
+	Name:        "mapping",
+	Aliases:     []string{"m"},
+	Usage:       "Get, put and diff the mappings of indices.",
+	ArgsUsage:   `get|put|diff`,
+	Description: `Display the fields of mappings, update the mapping, or find the fields with conflicting types.`,
+	Subcommands: []cli.Command{
+		// mapping get
+		indicesMappingGetCommand,
+		// mapping put
+		indicesMappingPutCommand,
+		// mapping diff
+		indicesMappingDiffCommand,
+	},
+}
+
+// mapping get              index [--field a,b*]
+var indicesMappingGetCommand = cli.Command{
+	Name:      "get",
+	Usage:     "Display the fields of the indices mappings.",
+	ArgsUsage: `index [--field host,user.*] [--format json]`,
+	Description: `Display the fields and their types of the indices mappings, the objects are flattened to
+   the dotted field names, ex: user.name. Only the fields matched --field are displayed with it.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "field",
+			Value: "",
+			Usage: "only get the mapping of fields, ex: host,user.*.",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "set the format of output('text' (default), or 'json').",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "get")
+			logrus.Fatalf("Must provide index for mapping get command!")
+		}
+
+		return indicesMappingGetCmd(context)
+	},
+}
+
+// mapping put              index -f mapping.json|-
+var indicesMappingPutCommand = cli.Command{
+	Name:      "put",
+	Usage:     "Add the fields to the mapping of indices.",
+	ArgsUsage: `index -f mapping.json|- [--type _doc]`,
+	Description: `Put the mapping of file (or stdin with '-') to the indices, ex: '{"properties":{"host":{"type":"keyword"}}}'.
+   The type of mapping is the existing type of index by default.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file, f",
+			Value: "",
+			Usage: "set the mapping file, '-' for stdin.",
+		},
+		cli.StringFlag{
+			Name:  "type",
+			Value: "",
+			Usage: "set the type of mapping, default the existing type of index or _doc.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 || context.String("file") == "" {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "put")
+			logrus.Fatalf("Must provide index and --file for mapping put command!")
+		}
+
+		return indicesMappingPutCmd(context)
+	},
+}
+
+// mapping diff             index [index2 | --template [name]]
+var indicesMappingDiffCommand = cli.Command{
+	Name:      "diff",
+	Usage:     "Compare the fields of mappings and find the conflicting types.",
+	ArgsUsage: `index index2 | index --template [name]`,
+	Description: `Compare the fields of the index mapping with the other index, or with the index templates
+   matched the index (or the template of name). The fields with different types are conflicts, which
+   break the index patterns of kibana.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "template, t",
+			Usage: "compare with the index templates matched the index, or the template of the second argument.",
+		},
+		cli.BoolFlag{
+			Name:  "all, a",
+			Usage: "display the same fields as well.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 || context.NArg() > 2 || (context.NArg() == 1 && !context.Bool("template")) {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "diff")
+			logrus.Fatalf("Must provide two indices, or index and --template for mapping diff command!")
+		}
+
+		return indicesMappingDiffCmd(context)
+	},
+}
+
+// mappingField is a field of mapping.
+type mappingField struct {
+	Index string `json:"index"`
+	Type  string `json:"type"`
+	Field string `json:"field"`
+	Kind  string `json:"field_type"`
+}
+
+// mappingFields sort the fields by index, type and field.
+type mappingFields []*mappingField
+
+func (f mappingFields) Len() int      { return len(f) }
+func (f mappingFields) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f mappingFields) Less(i, j int) bool {
+	if f[i].Index != f[j].Index {
+		return f[i].Index < f[j].Index
+	}
+	if f[i].Type != f[j].Type {
+		return f[i].Type < f[j].Type
+	}
+	return f[i].Field < f[j].Field
+}
+
+// flattenMappingFields flatten the properties to the dotted field names with the types,
+// the multi-fields are included, ex: name.raw.
+func flattenMappingFields(prefix string, properties map[string]interface{}, fields map[string]string) {
+	for name, value := range properties {
+		field, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		kind, _ := field["type"].(string)
+		if kind == "" {
+			kind = "object"
+		}
+		fields[prefix+name] = kind
+
+		if nested, ok := field["properties"].(map[string]interface{}); ok {
+			flattenMappingFields(prefix+name+".", nested, fields)
+		}
+		if multi, ok := field["fields"].(map[string]interface{}); ok {
+			flattenMappingFields(prefix+name+".", multi, fields)
+		}
+	}
+}
+
+// typeMappingFields get the fields of mappings by type, the mappings is {type: {properties: {...}}}.
+func typeMappingFields(mappings map[string]interface{}) map[string]map[string]string {
+	types := make(map[string]map[string]string)
+	for typ, value := range mappings {
+		fields := make(map[string]string)
+		if mapping, ok := value.(map[string]interface{}); ok {
+			if properties, ok := mapping["properties"].(map[string]interface{}); ok {
+				flattenMappingFields("", properties, fields)
+			}
+		}
+		types[typ] = fields
+	}
+	return types
+}
+
+// getIndicesMappings get the mappings of indices, the result is {index: {type: {field: field_type}}}.
+func getIndicesMappings(client *elastic.Client, ctx ctx.Context, index string) (map[string]map[string]map[string]string, error) {
+	res, err := client.GetMapping().Index(index).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	indices := make(map[string]map[string]map[string]string)
+	for name, value := range res {
+		var mappings map[string]interface{}
+		if indexMapping, ok := value.(map[string]interface{}); ok {
+			mappings, _ = indexMapping["mappings"].(map[string]interface{})
+		}
+		indices[name] = typeMappingFields(mappings)
+	}
+	return indices, nil
+}
+
+func indicesMappingGetCmd(context *cli.Context) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	index := context.Args().Get(0)
+	var fields []*mappingField
+	if field := context.String("field"); field != "" {
+		fields, err = getFieldMappings(client, ctx, index, strings.Split(field, ","))
+	} else {
+		var indices map[string]map[string]map[string]string
+		indices, err = getIndicesMappings(client, ctx, index)
+		for name, types := range indices {
+			for typ, typeFields := range types {
+				for field, kind := range typeFields {
+					fields = append(fields, &mappingField{Index: name, Type: typ, Field: field, Kind: kind})
+				}
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	sort.Sort(mappingFields(fields))
+
+	format := context.String("format")
+	switch format {
+	case "text":
+		printMappingFields(fields)
+	case "json":
+		jsonStr, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		fmt.Println(jsonPrettyPrint(string(jsonStr)))
+	default:
+		return fmt.Errorf("unknown format %q", context.String("format"))
+	}
+
+	return nil
+}
+
+// getFieldMappings get the mappings of the fields, the response is
+// {index: {mappings: {type: {full_name: {full_name: name, mapping: {leaf_name: {type: kind}}}}}}}.
+func getFieldMappings(client *elastic.Client, ctx ctx.Context, index string, names []string) ([]*mappingField, error) {
+	res, err := client.GetFieldMapping().Index(index).Field(names...).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []*mappingField
+	for name, value := range res {
+		indexMapping, _ := value.(map[string]interface{})
+		mappings, _ := indexMapping["mappings"].(map[string]interface{})
+		for typ, value := range mappings {
+			typeMapping, _ := value.(map[string]interface{})
+			for fullName, value := range typeMapping {
+				fieldMapping, _ := value.(map[string]interface{})
+				mapping, _ := fieldMapping["mapping"].(map[string]interface{})
+				for _, value := range mapping {
+					leaf, _ := value.(map[string]interface{})
+					kind, _ := leaf["type"].(string)
+					if kind == "" {
+						kind = "object"
+					}
+					fields = append(fields, &mappingField{Index: name, Type: typ, Field: fullName, Kind: kind})
+				}
+			}
+		}
+	}
+	return fields, nil
+}
+
+// index type field field_type
+func printMappingFields(fields []*mappingField) {
+	display := NewTableDisplay()
+	display.AddRow([]string{"index", "type", "field", "field_type"})
+	for _, field := range fields {
+		display.AddRow([]string{field.Index, field.Type, field.Field, field.Kind})
+	}
+	display.Flush()
+}
+
+func indicesMappingPutCmd(context *cli.Context) error {
+	data, err := readFileOrStdin(context.String("file"))
+	if err != nil {
+		return err
+	}
+	var mapping map[string]interface{}
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return fmt.Errorf("invalid mapping of %s: %s", context.String("file"), err)
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	index := context.Args().Get(0)
+	typ := context.String("type")
+	if typ == "" {
+		if typ, err = getIndexMappingType(client, ctx, index); err != nil {
+			return err
+		}
+	}
+
+	res, err := client.PutMapping().Index(index).Type(typ).BodyJson(mapping).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if !res.Acknowledged {
+		return fmt.Errorf("put mapping of %s not acknowledged", index)
+	}
+
+	fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] mapping %s of %s updated.", typ, index)))
+	return nil
+}
+
+// getIndexMappingType get the only type of the indices mappings, _doc if there is no type.
+func getIndexMappingType(client *elastic.Client, ctx ctx.Context, index string) (string, error) {
+	indices, err := getIndicesMappings(client, ctx, index)
+	if err != nil {
+		return "", err
+	}
+
+	types := make(map[string]bool)
+	for _, indexTypes := range indices {
+		for typ := range indexTypes {
+			types[typ] = true
+		}
+	}
+	if len(types) > 1 {
+		return "", fmt.Errorf("%s has multiple mapping types, must provide --type", index)
+	}
+	for typ := range types {
+		return typ, nil
+	}
+	return "_doc", nil
+}
+
+func indicesMappingDiffCmd(context *cli.Context) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	index := context.Args().Get(0)
+	left, err := getIndexFields(client, ctx, index)
+	if err != nil {
+		return err
+	}
+
+	var name string
+	var right map[string]string
+	if context.Bool("template") {
+		name, right, err = getTemplateFields(client, ctx, index, context.Args().Get(1))
+	} else {
+		name = context.Args().Get(1)
+		right, err = getIndexFields(client, ctx, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	conflicts := printMappingDiff(index, left, name, right, context.Bool("all"))
+	if conflicts > 0 {
+		return fmt.Errorf("%d fields have conflicting types between %s and %s", conflicts, index, name)
+	}
+
+	fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] no conflicting field between %s and %s.", index, name)))
+	return nil
+}
+
+// getIndexFields get the fields of a single index, the fields of all types are merged.
+func getIndexFields(client *elastic.Client, ctx ctx.Context, index string) (map[string]string, error) {
+	indices, err := getIndicesMappings(client, ctx, index)
+	if err != nil {
+		return nil, err
+	}
+	if len(indices) != 1 {
+		return nil, fmt.Errorf("%s matched %d indices, must be a single index", index, len(indices))
+	}
+
+	fields := make(map[string]string)
+	for _, types := range indices {
+		for _, typeFields := range types {
+			for field, kind := range typeFields {
+				fields[field] = kind
+			}
+		}
+	}
+	return fields, nil
+}
+
+// getTemplateFields get the fields of the template of name, or the templates matched the index
+// merged by order.
+func getTemplateFields(client *elastic.Client, ctx ctx.Context, index, name string) (string, map[string]string, error) {
+	templates, err := getIndexTemplates(client, ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var names []string
+	var mappings map[string]interface{}
+	for _, template := range templates {
+		matched := template.name == name
+		if name == "" {
+			for _, pattern := range template.patterns() {
+				if matchSimplePattern(pattern, index) {
+					matched = true
+					break
+				}
+			}
+		}
+		if matched {
+			names = append(names, template.name)
+			mappings = mergeMaps(mappings, template.Mappings)
+		}
+	}
+	if len(names) == 0 {
+		if name != "" {
+			return "", nil, fmt.Errorf("template %s not found", name)
+		}
+		return "", nil, errors.New("no template matched " + index)
+	}
+
+	fields := make(map[string]string)
+	for _, typeFields := range typeMappingFields(mappings) {
+		for field, kind := range typeFields {
+			fields[field] = kind
+		}
+	}
+	return "template " + strings.Join(names, ","), fields, nil
+}
+
+// printMappingDiff print the fields differ between left and right, and return the number of conflicts.
+// field left right status
+func printMappingDiff(leftName string, left map[string]string, rightName string, right map[string]string, all bool) int {
+	names := make(map[string]bool)
+	for field := range left {
+		names[field] = true
+	}
+	for field := range right {
+		names[field] = true
+	}
+	var fields []string
+	for field := range names {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	conflicts := 0
+	display := NewTableDisplay()
+	display.AddRow([]string{"field", leftName, rightName, "status"})
+	for _, field := range fields {
+		leftKind, rightKind := left[field], right[field]
+		var status string
+		switch {
+		case leftKind == "":
+			status = "only in " + rightName
+			leftKind = "-"
+		case rightKind == "":
+			status = "only in " + leftName
+			rightKind = "-"
+		case leftKind != rightKind:
+			status = "conflict"
+			conflicts++
+		default:
+			if !all {
+				continue
+			}
+			status = "same"
+		}
+		display.AddRow([]string{field, leftKind, rightKind, status})
+	}
+	display.Flush()
+	return conflicts
+}
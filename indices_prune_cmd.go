@@ -0,0 +1,303 @@
+package main
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+	"github.com/spf13/viper"
+)
+
+// actions of indices prune
+const (
+	pruneKeep   = "keep"
+	pruneClose  = "close"
+	pruneDelete = "delete"
+)
+
+// prune
+var indicesPruneCommand = cli.Command{
+	Name:      "prune",
+	Usage:     "Delete the time-based indices older than the retention.",
+	ArgsUsage: `-p 'logstash-*' -d 2006.01.02 -o 30d`,
+	Description: `Parse the date from the index names matched the pattern (or use the creation_date of index),
+   and delete the indices older than the retention. The newest N indices are always kept, N is the
+   max of --min-keep and 'prune.min_keep.<cluster>' (or 'prune.min_keep.default') in elastic-trib.yaml.
+   With --close-grace, the indices older than the retention are closed first, and deleted when they
+   are closed and older than the retention plus the grace period, so it works well in crontab.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "pattern, p",
+			Value: "",
+			Usage: "set the pattern of time-based indices, ex: logstash-*.",
+		},
+		cli.StringFlag{
+			Name:  "date-format, d",
+			Value: "2006.01.02",
+			Usage: "set the date format in index name with go layout, ex: 2006.01.02, 20060102.",
+		},
+		cli.StringFlag{
+			Name:  "older-than, o",
+			Value: "",
+			Usage: "set the retention of indices, ex: 30d, 12h.",
+		},
+		cli.StringFlag{
+			Name:  "close-grace",
+			Value: "",
+			Usage: "close the indices first and delete them after the grace period, ex: 7d.",
+		},
+		cli.IntFlag{
+			Name:  "min-keep",
+			Value: 0,
+			Usage: "always keep the newest N indices.",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only print the indices to close and delete.",
+		},
+		cli.BoolFlag{
+			Name:  "yes, y",
+			Usage: "Answer prune indices conform.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.String("pattern") == "" || context.String("older-than") == "" {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "prune")
+			return errors.New("indices prune must provide --pattern and --older-than")
+		}
+
+		return indicesPruneCmd(context)
+	},
+}
+
+// pruneIndex is a time-based index with the date and the prune action.
+type pruneIndex struct {
+	name   string
+	status string
+	date   time.Time
+	source string
+	action string
+}
+
+// pruneIndices sort the indices by date from the newest.
+type pruneIndices []*pruneIndex
+
+func (p pruneIndices) Len() int      { return len(p) }
+func (p pruneIndices) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p pruneIndices) Less(i, j int) bool {
+	if !p[i].date.Equal(p[j].date) {
+		return p[i].date.After(p[j].date)
+	}
+	return p[i].name > p[j].name
+}
+
+// parseRetention parse the retention with day unit (30d) or go duration (12h).
+func parseRetention(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid retention %s", value)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid retention %s", value)
+	}
+	return duration, nil
+}
+
+// getMinKeep get the min keep of the cluster from config, the default is used for unknown cluster.
+func getMinKeep(context *cli.Context) int {
+	minKeep := context.Int("min-keep")
+
+	key := "prune.min_keep.default"
+	if cluster := context.GlobalString("cluster"); cluster != "" && viper.IsSet("prune.min_keep."+cluster) {
+		key = "prune.min_keep." + cluster
+	}
+	if value := viper.GetInt(key); value > minKeep {
+		minKeep = value
+	}
+	return minKeep
+}
+
+func indicesPruneCmd(context *cli.Context) error {
+	retention, err := parseRetention(context.String("older-than"))
+	if err != nil {
+		return err
+	}
+	var grace time.Duration
+	if context.String("close-grace") != "" {
+		if grace, err = parseRetention(context.String("close-grace")); err != nil {
+			return err
+		}
+	}
+	minKeep := getMinKeep(context)
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	indices, err := getPruneIndices(client, ctx, context.String("pattern"), context.String("date-format"))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var toClose, toDelete []string
+	for i, index := range indices {
+		age := now.Sub(index.date)
+		switch {
+		case i < minKeep || age <= retention:
+			index.action = pruneKeep
+		case context.String("close-grace") == "":
+			index.action = pruneDelete
+		case index.status == "close" && age > retention+grace:
+			index.action = pruneDelete
+		case index.status == "close":
+			index.action = pruneKeep
+		default:
+			index.action = pruneClose
+		}
+
+		switch index.action {
+		case pruneClose:
+			toClose = append(toClose, index.name)
+		case pruneDelete:
+			toDelete = append(toDelete, index.name)
+		}
+	}
+
+	printPruneIndices(indices, now)
+	fmt.Printf("\nmin keep: %d, close: %d, delete: %d\n", minKeep, len(toClose), len(toDelete))
+	if len(toClose) == 0 && len(toDelete) == 0 {
+		fmt.Println(sgrBoldBlue("[OK] there is no index to prune."))
+		return nil
+	}
+	if context.Bool("dry-run") {
+		return nil
+	}
+
+	fmt.Println(sgrBoldBlue("[Attention] Close and delete above indices? type (yes) to conform prune."))
+	if !context.Bool("yes") {
+		YesOrDie(fmt.Sprintf("close %d and delete %d indices", len(toClose), len(toDelete)))
+	}
+
+	if len(toClose) > 0 {
+		if _, err := client.CloseIndex(strings.Join(toClose, ",")).Do(ctx); err != nil {
+			return err
+		}
+		fmt.Printf("closed: %s\n", strings.Join(toClose, " "))
+	}
+	if len(toDelete) > 0 {
+		if _, err := client.DeleteIndex(toDelete...).Do(ctx); err != nil {
+			return err
+		}
+		fmt.Printf("deleted: %s\n", strings.Join(toDelete, " "))
+	}
+
+	fmt.Println(sgrBoldBlue("[OK] indices pruned."))
+	return nil
+}
+
+// getPruneIndices get the indices matched the pattern sorted from the newest,
+// the date is parsed from the name, or the creation_date if failed.
+func getPruneIndices(client *elastic.Client, ctx ctx.Context, pattern, layout string) ([]*pruneIndex, error) {
+	res, err := client.CatIndicesService().Index(pattern).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := client.IndexGetSettings(pattern).
+		ExpandWildcards("open,closed").
+		FlatSettings(true).
+		Name("index.creation_date").
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var indices []*pruneIndex
+	for _, indexInfo := range res.Indices {
+		index := &pruneIndex{
+			name:   indexInfo.Index,
+			status: indexInfo.Status,
+			source: "name",
+		}
+
+		date, ok := parseIndexDate(indexInfo.Index, pattern, layout)
+		if !ok {
+			setting, found := settings[indexInfo.Index]
+			if !found {
+				return nil, fmt.Errorf("get creation_date of index %s failed", indexInfo.Index)
+			}
+			millis, err := strconv.ParseInt(getSettingString(setting.Settings, "index.creation_date"), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid creation_date of index %s", indexInfo.Index)
+			}
+			date, index.source = time.Unix(0, millis*int64(time.Millisecond)), "creation_date"
+		}
+		index.date = date
+
+		indices = append(indices, index)
+	}
+
+	sort.Sort(pruneIndices(indices))
+	return indices, nil
+}
+
+// parseIndexDate parse the date in the part of name matched '*' of pattern,
+// or at the end of name, ex: logstash-2006.01.02, app-2006.01.02-000001.
+func parseIndexDate(name, pattern, layout string) (time.Time, bool) {
+	var candidates []string
+	if i := strings.Index(pattern, "*"); i >= 0 {
+		prefix, suffix := pattern[:i], strings.TrimLeft(pattern[i:], "*")
+		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) && len(name) >= len(prefix)+len(suffix) {
+			candidates = append(candidates, name[len(prefix):len(name)-len(suffix)])
+		}
+	}
+	if len(name) >= len(layout) {
+		candidates = append(candidates, name[len(name)-len(layout):])
+	}
+
+	for _, candidate := range candidates {
+		if date, err := time.ParseInLocation(layout, candidate, time.Local); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// index status date source age action
+func printPruneIndices(indices []*pruneIndex, now time.Time) {
+	display := NewTableDisplay()
+	display.AddRow([]string{"index", "status", "date", "source", "age", "action"})
+	for _, index := range indices {
+		action := index.action
+		if action != pruneKeep {
+			action = sgrBoldRed(action)
+		}
+		display.AddRow([]string{
+			index.name,
+			index.status,
+			index.date.Format("2006-01-02 15:04"),
+			index.source,
+			fmt.Sprintf("%.1fd", now.Sub(index.date).Hours()/24),
+			action})
+	}
+	display.Flush()
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+// reindex
+var indicesReindexCommand = cli.Command{
+	Name:      "reindex",
+	Usage:     "Copy the documents from the source index to the destination index.",
+	ArgsUsage: `--src a --dest b [--query '{"term":{"user":"kimchy"}}'] [--slices auto] [--remote http://host:9200]`,
+	Description: `Start the reindex as a task and follow the progress of the task until it's done, Ctrl-C
+   cancels the task. Follow a running task with --task, and change its throttle with --rethrottle.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "src",
+			Value: "",
+			Usage: "set the source indices, ex: a,b.",
+		},
+		cli.StringFlag{
+			Name:  "dest",
+			Value: "",
+			Usage: "set the destination index.",
+		},
+		cli.StringFlag{
+			Name:  "query, q",
+			Value: "",
+			Usage: "only reindex the documents matched the query json.",
+		},
+		cli.StringFlag{
+			Name:  "slices",
+			Value: "1",
+			Usage: "set the number of slices to reindex in parallel, or 'auto'.",
+		},
+		cli.IntFlag{
+			Name:  "size",
+			Value: 0,
+			Usage: "set the batch size of scroll, default 1000.",
+		},
+		cli.StringFlag{
+			Name:  "remote",
+			Value: "",
+			Usage: "reindex from the remote cluster, ex: http://otherhost:9200.",
+		},
+		cli.StringFlag{
+			Name:  "remote-user",
+			Value: "",
+			Usage: "set the username of remote cluster.",
+		},
+		cli.StringFlag{
+			Name:  "remote-password",
+			Value: "",
+			Usage: "set the password of remote cluster.",
+		},
+		cli.IntFlag{
+			Name:  "requests-per-second",
+			Value: -1,
+			Usage: "set the throttle of reindex in documents per second, -1 means no throttle.",
+		},
+		cli.StringFlag{
+			Name:  "task",
+			Value: "",
+			Usage: "follow a running reindex task instead of starting a new one, ex: oTUltX4IQMOUUVeiohTt8A:12345.",
+		},
+		cli.Float64Flag{
+			Name:  "rethrottle",
+			Value: 0,
+			Usage: "change the throttle of the running task with --task, -1 means no throttle.",
+		},
+		cli.DurationFlag{
+			Name:  "interval, i",
+			Value: 5 * time.Second,
+			Usage: "set the interval to print the progress of task.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.String("task") == "" && (context.String("src") == "" || context.String("dest") == "") {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "reindex")
+			return errors.New("indices reindex must provide --src and --dest, or --task")
+		}
+		if context.String("task") == "" && context.IsSet("rethrottle") {
+			return errors.New("indices reindex --rethrottle must provide --task")
+		}
+		if query := context.String("query"); query != "" && !isJSON(query) {
+			return fmt.Errorf("invalid query json %s", query)
+		}
+		if context.String("remote") != "" && context.String("slices") != "1" {
+			return errors.New("indices reindex from remote does not support slices")
+		}
+
+		return indicesReindexCmd(context)
+	},
+}
+
+// taskStatus is the status of reindex, update by query and delete by query task.
+type taskStatus struct {
+	Total             int64   `json:"total"`
+	Updated           int64   `json:"updated"`
+	Created           int64   `json:"created"`
+	Deleted           int64   `json:"deleted"`
+	Batches           int64   `json:"batches"`
+	VersionConflicts  int64   `json:"version_conflicts"`
+	Noops             int64   `json:"noops"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+}
+
+// done is the number of processed documents.
+func (s *taskStatus) done() int64 {
+	return s.Created + s.Updated + s.Deleted + s.Noops + s.VersionConflicts
+}
+
+func indicesReindexCmd(context *cli.Context) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	taskID := context.String("task")
+	if taskID != "" {
+		if context.IsSet("rethrottle") {
+			if _, err := client.Rethrottle().Action("_reindex").TaskId(taskID).RequestsPerSecond(context.Float64("rethrottle")).Do(ctx); err != nil {
+				return err
+			}
+			fmt.Printf("task %s rethrottled to %v requests per second.\n", taskID, context.Float64("rethrottle"))
+		}
+	} else {
+		if taskID, err = startReindex(client, ctx, context); err != nil {
+			return err
+		}
+		fmt.Printf("reindex %s into %s started, task: %s\n", context.String("src"), context.String("dest"), taskID)
+	}
+
+	res, err := followTask(client, ctx, taskID, context.Duration("interval"))
+	if err != nil {
+		return err
+	}
+	printTaskResponse(res)
+	if len(res.Failures) > 0 {
+		return fmt.Errorf("reindex failed on %d documents", len(res.Failures))
+	}
+	if res.Canceled != "" {
+		return fmt.Errorf("reindex canceled: %s", res.Canceled)
+	}
+
+	fmt.Println(sgrBoldBlue("[OK] reindex done."))
+	return nil
+}
+
+// startReindex start the reindex task and return the task id.
+func startReindex(client *elastic.Client, ctx ctx.Context, context *cli.Context) (string, error) {
+	src := elastic.NewReindexSource().Index(strings.Split(context.String("src"), ",")...)
+	if query := context.String("query"); query != "" {
+		src = src.Query(elastic.NewRawStringQuery(query))
+	}
+	if size := context.Int("size"); size > 0 {
+		src = src.Size(size)
+	}
+	if remote := context.String("remote"); remote != "" {
+		info := elastic.NewReindexRemoteInfo().Host(remote)
+		if user := context.String("remote-user"); user != "" {
+			info = info.Username(user).Password(context.String("remote-password"))
+		}
+		src = src.RemoteInfo(info)
+	} else {
+		// the destination index created by reindex has the dynamic mappings.
+		exists, err := client.IndexExists(context.String("dest")).Do(ctx)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			logrus.Warnf("destination %s does not exist, it's created with the dynamic mappings", context.String("dest"))
+		}
+	}
+
+	service := client.Reindex().
+		Source(src).
+		DestinationIndex(context.String("dest")).
+		RequestsPerSecond(context.Int("requests-per-second"))
+	if slices := context.String("slices"); slices != "1" {
+		service = service.Slices(slices)
+	}
+
+	res, err := service.DoAsync(ctx)
+	if err != nil {
+		return "", err
+	}
+	return res.TaskId, nil
+}
+
+// followTask print the progress of task until it's completed, the task is canceled by Ctrl-C.
+func followTask(client *elastic.Client, ctx ctx.Context, taskID string, interval time.Duration) (*elastic.BulkIndexByScrollResponse, error) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	var last *taskStatus
+	lastTime := time.Now()
+	refresh := time.After(0)
+	for {
+		select {
+		case <-sigs:
+			fmt.Println()
+			if err := cancelTask(client, ctx, taskID); err != nil {
+				return nil, fmt.Errorf("cancel task %s failed: %s", taskID, err)
+			}
+			fmt.Println(sgrBoldRed(fmt.Sprintf("task %s canceled.", taskID)))
+			refresh = time.After(0)
+			continue
+		case <-refresh:
+		}
+		refresh = time.After(interval)
+
+		res, err := client.TasksGetTask().TaskId(taskID).Do(ctx)
+		if elastic.IsNotFound(err) {
+			return nil, fmt.Errorf("task %s not found", taskID)
+		} else if err != nil {
+			logrus.Warnf("get task %s failed: %s", taskID, err)
+			continue
+		}
+		if res.Completed {
+			if res.Error != nil {
+				return nil, fmt.Errorf("task %s failed: %s: %s", taskID, res.Error.Type, res.Error.Reason)
+			}
+			ret := new(elastic.BulkIndexByScrollResponse)
+			if err := json.Unmarshal(res.Response, ret); err != nil {
+				return nil, err
+			}
+			return ret, nil
+		}
+		if res.Task == nil {
+			continue
+		}
+
+		status, err := parseTaskStatus(res.Task.Status)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		printTaskProgress(status, last, now.Sub(lastTime))
+		last, lastTime = status, now
+	}
+}
+
+// parseTaskStatus parse the status of task from the raw status.
+func parseTaskStatus(raw interface{}) (*taskStatus, error) {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	status := new(taskStatus)
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// cancelTask cancel the task with id in the form nodeId:taskId.
+func cancelTask(client *elastic.Client, ctx ctx.Context, taskID string) error {
+	parts := strings.SplitN(taskID, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid task id %s", taskID)
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid task id %s", taskID)
+	}
+	_, err = client.TasksCancel().TaskIdFromNodeAndId(parts[0], id).Do(ctx)
+	return err
+}
+
+// printTaskProgress print the documents and the rate since the last status.
+func printTaskProgress(status, last *taskStatus, elapsed time.Duration) {
+	progress := fmt.Sprintf("created: %d, updated: %d, deleted: %d, conflicts: %d, total: %d",
+		status.Created, status.Updated, status.Deleted, status.VersionConflicts, status.Total)
+	if status.Total > 0 {
+		progress += fmt.Sprintf(" (%.1f%%)", float64(status.done())*100/float64(status.Total))
+	}
+
+	if last != nil && elapsed > 0 {
+		rate := float64(status.done()-last.done()) / elapsed.Seconds()
+		progress += fmt.Sprintf(", rate: %.0f docs/s", rate)
+		if rate > 0 && status.Total > status.done() {
+			eta := time.Duration(float64(status.Total-status.done())/rate) * time.Second
+			progress += fmt.Sprintf(", eta: %s", eta)
+		}
+	}
+	fmt.Println(progress)
+}
+
+// took total created updated deleted batches version_conflicts noops failures
+func printTaskResponse(res *elastic.BulkIndexByScrollResponse) {
+	display := NewTableDisplay()
+	display.AddRow([]string{"took", "total", "created", "updated", "deleted", "batches", "version_conflicts", "noops", "failures"})
+	display.AddRow([]string{
+		(time.Duration(res.Took) * time.Millisecond).String(),
+		strconv.FormatInt(res.Total, 10),
+		strconv.FormatInt(res.Created, 10),
+		strconv.FormatInt(res.Updated, 10),
+		strconv.FormatInt(res.Deleted, 10),
+		strconv.FormatInt(res.Batches, 10),
+		strconv.FormatInt(res.VersionConflicts, 10),
+		strconv.FormatInt(res.Noops, 10),
+		strconv.Itoa(len(res.Failures))})
+	display.Flush()
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+	"github.com/spf13/viper"
+)
+
+// rollover              alias
+var indicesRolloverCommand = cli.Command{
+	Name:      "rollover",
+	Usage:     "Rollover an alias to a new index when the conditions are met.",
+	ArgsUsage: `alias [--max-age 1d] [--max-docs 100000000] [--max-size 50gb]`,
+	Description: `Rollover the alias to a new index if any of the conditions is met, with --dry-run
+   only check which conditions are matched. With --all-from-config, rollover all the aliases of
+   the policy list 'rollover.<cluster>' (or 'rollover.default') in elastic-trib.yaml.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "max-age",
+			Value: "",
+			Usage: "rollover when the index is older than max age, ex: 7d, 12h.",
+		},
+		cli.Int64Flag{
+			Name:  "max-docs",
+			Value: 0,
+			Usage: "rollover when the index contains max docs.",
+		},
+		cli.StringFlag{
+			Name:  "max-size",
+			Value: "",
+			Usage: "rollover when the size of primary shards reaches max size, ex: 50gb.",
+		},
+		cli.StringFlag{
+			Name:  "new-index",
+			Value: "",
+			Usage: "set the name of new index, default increase the number suffix of old index.",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only check the conditions, the alias is not rolled over.",
+		},
+		cli.BoolFlag{
+			Name:  "all-from-config",
+			Usage: "rollover all the aliases of the policy list in elastic-trib.yaml.",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "set the format of output('text' (default), or 'json').",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.Bool("all-from-config") {
+			if context.NArg() != 0 {
+				return errors.New("indices rollover --all-from-config must not provide alias")
+			}
+			if context.String("max-age") != "" || context.Int64("max-docs") != 0 || context.String("max-size") != "" {
+				return errors.New("indices rollover --all-from-config must not provide conditions, they are read from the config")
+			}
+			if context.String("new-index") != "" {
+				return errors.New("indices rollover --all-from-config must not provide --new-index")
+			}
+		} else if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "rollover")
+			logrus.Fatalf("Must provide alias for rollover command!")
+		}
+
+		return indicesRolloverCmd(context)
+	},
+}
+
+// rolloverPolicy is the rollover conditions of an alias,
+// it's also the item of policy list in elastic-trib.yaml.
+type rolloverPolicy struct {
+	Alias    string `mapstructure:"alias" json:"alias"`
+	MaxAge   string `mapstructure:"max_age" json:"max_age,omitempty"`
+	MaxDocs  int64  `mapstructure:"max_docs" json:"max_docs,omitempty"`
+	MaxSize  string `mapstructure:"max_size" json:"max_size,omitempty"`
+	NewIndex string `mapstructure:"new_index" json:"new_index,omitempty"`
+}
+
+// rolloverResult is the result of rollover an alias.
+type rolloverResult struct {
+	Alias    string                           `json:"alias"`
+	Response *elastic.IndicesRolloverResponse `json:"response,omitempty"`
+	Error    string                           `json:"error,omitempty"`
+}
+
+// getRolloverPolicies get the rollover policy list of the cluster from config.
+func getRolloverPolicies(context *cli.Context) ([]*rolloverPolicy, error) {
+	key := "rollover.default"
+	if cluster := context.GlobalString("cluster"); cluster != "" && viper.IsSet("rollover."+cluster) {
+		key = "rollover." + cluster
+	}
+
+	var policies []*rolloverPolicy
+	if err := viper.UnmarshalKey(key, &policies); err != nil {
+		return nil, fmt.Errorf("invalid rollover policy list %s in %s: %s", key, viper.ConfigFileUsed(), err)
+	}
+	if len(policies) == 0 {
+		return nil, fmt.Errorf("no rollover policy list %s in %s", key, viper.ConfigFileUsed())
+	}
+	return policies, nil
+}
+
+func indicesRolloverCmd(context *cli.Context) error {
+	var policies []*rolloverPolicy
+	if context.Bool("all-from-config") {
+		var err error
+		if policies, err = getRolloverPolicies(context); err != nil {
+			return err
+		}
+	} else {
+		policies = append(policies, &rolloverPolicy{
+			Alias:    context.Args().Get(0),
+			MaxAge:   context.String("max-age"),
+			MaxDocs:  context.Int64("max-docs"),
+			MaxSize:  context.String("max-size"),
+			NewIndex: context.String("new-index"),
+		})
+	}
+
+	for _, policy := range policies {
+		if policy.Alias == "" {
+			return errors.New("the alias of rollover policy is empty")
+		}
+		if policy.MaxAge == "" && policy.MaxDocs <= 0 && policy.MaxSize == "" {
+			return fmt.Errorf("rollover %s must provide one of max_age, max_docs and max_size", policy.Alias)
+		}
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	// go on with the next alias when failed, the cron job can rollover the others.
+	var results []*rolloverResult
+	failed := 0
+	for _, policy := range policies {
+		result := &rolloverResult{Alias: policy.Alias}
+		result.Response, err = rolloverAlias(client, ctx, policy, context.Bool("dry-run"))
+		if err != nil {
+			result.Error = err.Error()
+			failed++
+		}
+		results = append(results, result)
+	}
+
+	switch context.String("format") {
+	case "text":
+		printRolloverResults(results)
+	case "json":
+		jsonStr, err := json.Marshal(results)
+		if err != nil {
+			return err
+		}
+		fmt.Println(jsonPrettyPrint(string(jsonStr)))
+	default:
+		return fmt.Errorf("unknown format %s", context.String("format"))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("rollover failed on %d of %d aliases", failed, len(policies))
+	}
+	return nil
+}
+
+func rolloverAlias(client *elastic.Client, ctx ctx.Context, policy *rolloverPolicy, dryRun bool) (*elastic.IndicesRolloverResponse, error) {
+	service := client.RolloverIndex(policy.Alias).DryRun(dryRun)
+	if policy.NewIndex != "" {
+		service = service.NewIndex(policy.NewIndex)
+	}
+	if policy.MaxAge != "" {
+		service = service.AddMaxIndexAgeCondition(policy.MaxAge)
+	}
+	if policy.MaxDocs > 0 {
+		service = service.AddMaxIndexDocsCondition(policy.MaxDocs)
+	}
+	if policy.MaxSize != "" {
+		service = service.AddMaxIndexSizeCondition(policy.MaxSize)
+	}
+	return service.Do(ctx)
+}
+
+// alias old_index new_index rolled_over dry_run conditions
+func printRolloverResults(results []*rolloverResult) {
+	display := NewTableDisplay()
+	display.AddRow([]string{"alias", "old_index", "new_index", "rolled_over", "dry_run", "conditions"})
+	for _, result := range results {
+		if result.Response == nil {
+			display.AddRow([]string{result.Alias, "-", "-", "-", "-", sgrBoldRed(result.Error)})
+			continue
+		}
+
+		// the conditions are "[max_age: 7d]": true
+		var conditions []string
+		for condition, matched := range result.Response.Conditions {
+			conditions = append(conditions, fmt.Sprintf("%s=%s", condition, strconv.FormatBool(matched)))
+		}
+		sort.Strings(conditions)
+		display.AddRow([]string{
+			result.Alias,
+			result.Response.OldIndex,
+			result.Response.NewIndex,
+			strconv.FormatBool(result.Response.RolledOver),
+			strconv.FormatBool(result.Response.DryRun),
+			strings.Join(conditions, " ")})
+	}
+	display.Flush()
+}
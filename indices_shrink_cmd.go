@@ -0,0 +1,374 @@
+package main
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+const requireNameStr = "index.routing.allocation.require._name"
+
+// shrink
+var indicesShrinkCommand = cli.Command{
+	Name:      "shrink",
+	Usage:     "Shrink an index into a new index with fewer primary shards.",
+	ArgsUsage: `source target [-s 1] [--node name]`,
+	Description: `Shrink the source index: relocate a copy of every shard to one node, block the writes,
+   wait for the relocation, shrink into the target index, wait for the target green, swap the aliases
+   of source to target, and delete the source with --delete-source. The node with the most available
+   disk is used if --node is not provided.`,
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "shards, s",
+			Value: 1,
+			Usage: "set the number of primary shards of target, must be a factor of the source.",
+		},
+		cli.StringFlag{
+			Name:  "node",
+			Value: "",
+			Usage: "set the name of node to relocate the shards to.",
+		},
+		cli.BoolFlag{
+			Name:  "delete-source",
+			Usage: "delete the source index after the target is green.",
+		},
+		cli.DurationFlag{
+			Name:  "timeout, t",
+			Value: time.Hour,
+			Usage: "set the max time to wait for the relocation and the target green.",
+		},
+		cli.DurationFlag{
+			Name:  "interval, i",
+			Value: 10 * time.Second,
+			Usage: "set the interval to check the relocation and the target status.",
+		},
+		cli.BoolFlag{
+			Name:  "yes, y",
+			Usage: "Answer shrink index conform.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 2 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "shrink")
+			logrus.Fatalf("Must provide source and target index for shrink command!")
+		}
+
+		return indicesShrinkCmd(context)
+	},
+}
+
+// shrinkPlan is the checked source, target and node of shrink.
+type shrinkPlan struct {
+	source   string
+	target   string
+	shards   int
+	pri      int
+	rep      int
+	priSize  int64
+	node     string
+	avail    int64
+	aliases  map[string]interface{}
+	deleteIt bool
+}
+
+// rawAliasAction is an alias action with the raw definition of alias,
+// so the filter and routing of the alias are kept.
+type rawAliasAction struct {
+	action string
+	body   map[string]interface{}
+}
+
+// Source returns the JSON-serializable data of alias action.
+func (a *rawAliasAction) Source() (interface{}, error) {
+	return map[string]interface{}{a.action: a.body}, nil
+}
+
+func indicesShrinkCmd(context *cli.Context) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	plan, err := getShrinkPlan(client, ctx, context)
+	if err != nil {
+		return err
+	}
+
+	printShrinkPlan(plan)
+	fmt.Println(sgrBoldBlue("[Attention] Shrink above index? the writes of source are blocked, type (yes) to conform shrink."))
+	if !context.Bool("yes") {
+		YesOrDie(fmt.Sprintf("shrink %s into %s", plan.source, plan.target))
+	}
+
+	if err := shrinkIndex(client, ctx, plan, context.Duration("timeout"), context.Duration("interval")); err != nil {
+		// unblock the writes of source, and let it move away from the node.
+		reset := map[string]interface{}{requireNameStr: nil, "index.blocks.write": nil}
+		if resetErr := putIndexSettings(client, ctx, plan.source, reset); resetErr != nil {
+			return fmt.Errorf("%s, reset the settings of %s failed: %s, remove %s and index.blocks.write of it by hand",
+				err, plan.source, resetErr, requireNameStr)
+		}
+		logrus.Warnf("shrink failed, the writes of %s unblocked", plan.source)
+		return err
+	}
+
+	fmt.Printf("[6/6] swap %d aliases from %s to %s.\n", len(plan.aliases), plan.source, plan.target)
+	if err := swapIndexAliases(client, ctx, plan.source, plan.target, plan.aliases); err != nil {
+		return fmt.Errorf("%s, the writes of %s are still blocked by index.blocks.write", err, plan.source)
+	}
+
+	if plan.deleteIt {
+		if _, err := client.DeleteIndex(plan.source).Do(ctx); err != nil {
+			return err
+		}
+		fmt.Printf("deleted: %s\n", plan.source)
+	} else {
+		// the source is still blocked for writes, only let it move away from the node.
+		if err := putIndexSettings(client, ctx, plan.source, map[string]interface{}{requireNameStr: nil}); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] %s shrunk into %s.", plan.source, plan.target)))
+	return nil
+}
+
+// shrinkIndex relocate the source to the node, block its writes, shrink it into the target and wait for
+// the target green (step 1 to 5).
+func shrinkIndex(client *elastic.Client, ctx ctx.Context, plan *shrinkPlan, timeout, interval time.Duration) error {
+	fmt.Printf("[1/6] relocate a copy of every shard of %s to %s.\n", plan.source, plan.node)
+	if err := putIndexSettings(client, ctx, plan.source, map[string]interface{}{requireNameStr: plan.node}); err != nil {
+		return err
+	}
+
+	fmt.Printf("[2/6] block the writes of %s.\n", plan.source)
+	if err := putIndexSettings(client, ctx, plan.source, map[string]interface{}{"index.blocks.write": true}); err != nil {
+		return err
+	}
+
+	fmt.Printf("[3/6] wait for the relocation to %s.\n", plan.node)
+	if err := waitForShardsOnNode(client, ctx, plan.source, plan.node, plan.pri, timeout, interval); err != nil {
+		return err
+	}
+
+	fmt.Printf("[4/6] shrink %s into %s with %d shards.\n", plan.source, plan.target, plan.shards)
+	body := map[string]interface{}{
+		"settings": map[string]interface{}{
+			"index.number_of_shards":   plan.shards,
+			"index.number_of_replicas": plan.rep,
+			requireNameStr:             nil,
+			"index.blocks.write":       nil,
+		},
+	}
+	res, err := client.ShrinkIndex(plan.source, plan.target).BodyJson(body).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if !res.Acknowledged {
+		logrus.Warnf("shrink %s not acknowledged, wait for the target green", plan.source)
+	}
+
+	fmt.Printf("[5/6] wait for %s green.\n", plan.target)
+	return waitForIndexGreen(client, ctx, plan.target, timeout, interval)
+}
+
+// getShrinkPlan check the source, target and node of shrink.
+func getShrinkPlan(client *elastic.Client, ctx ctx.Context, context *cli.Context) (*shrinkPlan, error) {
+	plan := &shrinkPlan{
+		source:   context.Args().Get(0),
+		target:   context.Args().Get(1),
+		shards:   context.Int("shards"),
+		node:     context.String("node"),
+		deleteIt: context.Bool("delete-source"),
+	}
+
+	res, err := client.CatIndicesService().Index(plan.source).Bytes("b").Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Indices) != 1 || res.Indices[0].Index != plan.source {
+		return nil, fmt.Errorf("source %s must be one index, not an alias or a pattern", plan.source)
+	}
+	indexInfo := res.Indices[0]
+	if indexInfo.Status != "open" || indexInfo.Health != "green" {
+		return nil, fmt.Errorf("source %s is %s and %s, must be open and green", plan.source, indexInfo.Status, indexInfo.Health)
+	}
+	plan.pri, _ = strconv.Atoi(indexInfo.Pri)
+	plan.rep, _ = strconv.Atoi(indexInfo.Rep)
+	plan.priSize, _ = strconv.ParseInt(indexInfo.StoreSize, 10, 64)
+
+	if plan.shards <= 0 || plan.shards >= plan.pri || plan.pri%plan.shards != 0 {
+		return nil, fmt.Errorf("the shards of target must be a factor of %d shards of source and less than it", plan.pri)
+	}
+
+	exists, err := client.IndexExists(plan.target).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("target %s already exists", plan.target)
+	}
+
+	allocRes, err := client.CatAllocService().Bytes("b").Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	node := plan.node
+	for _, allocInfo := range allocRes.Allocs {
+		// the unassigned shards are in the UNASSIGNED row.
+		if allocInfo.Node == "UNASSIGNED" || allocInfo.Avail == "" {
+			continue
+		}
+		avail, _ := strconv.ParseInt(allocInfo.Avail, 10, 64)
+		if (node == "" && avail > plan.avail) || allocInfo.Node == node {
+			plan.node, plan.avail = allocInfo.Node, avail
+		}
+	}
+	if plan.node == "" || (node != "" && plan.avail == 0) {
+		return nil, fmt.Errorf("data node %s not found", node)
+	}
+	if plan.avail <= plan.priSize {
+		return nil, fmt.Errorf("node %s has %s available, not enough for %s of primary shards", plan.node,
+			formatBytes(plan.avail), formatBytes(plan.priSize))
+	}
+
+	indices, err := client.IndexGet(plan.source).Feature("_aliases").Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if index, ok := indices[plan.source]; ok {
+		plan.aliases = index.Aliases
+	}
+	return plan, nil
+}
+
+// putIndexSettings update the settings of index, reset the setting with nil value.
+func putIndexSettings(client *elastic.Client, ctx ctx.Context, index string, settings map[string]interface{}) error {
+	res, err := client.IndexPutSettings(index).FlatSettings(true).BodyJson(settings).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if !res.Acknowledged {
+		return fmt.Errorf("update settings of %s not acknowledged", index)
+	}
+	return nil
+}
+
+// waitForShardsOnNode wait until a started copy of every shard is on the node and no shard is relocating.
+func waitForShardsOnNode(client *elastic.Client, ctx ctx.Context, index, node string, pri int,
+	timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		res, err := client.CatShardsService().Index(index).Do(ctx)
+		if err != nil {
+			logrus.Warnf("get shards of %s failed: %s", index, err)
+		} else {
+			onNode := make(map[string]bool)
+			moving := 0
+			for _, shardInfo := range res.Shards {
+				if shardInfo.State == "STARTED" && shardInfo.Node == node {
+					onNode[shardInfo.Shard] = true
+				}
+				if shardInfo.State == "RELOCATING" || shardInfo.State == "INITIALIZING" {
+					moving++
+				}
+			}
+			if len(onNode) == pri && moving == 0 {
+				return nil
+			}
+			fmt.Printf("shards on %s: %d/%d, relocating or initializing: %d\n", node, len(onNode), pri, moving)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("shards of %s not relocated to %s in %s, check with 'cluster explain'", index, node, timeout)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// waitForIndexGreen wait until the health of index is green.
+func waitForIndexGreen(client *elastic.Client, ctx ctx.Context, index string, timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		res, err := client.ClusterHealth().Index(index).Do(ctx)
+		if err != nil {
+			logrus.Warnf("get health of %s failed: %s", index, err)
+		} else if res.Status == "green" {
+			return nil
+		} else {
+			fmt.Printf("%s is %s, initializing: %d, unassigned: %d\n", index, res.Status, res.InitializingShards, res.UnassignedShards)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s not green in %s", index, timeout)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// swapIndexAliases move the aliases from index to the other atomically, the alias definitions are kept.
+func swapIndexAliases(client *elastic.Client, ctx ctx.Context, from, to string, aliases map[string]interface{}) error {
+	if len(aliases) == 0 {
+		return nil
+	}
+
+	service := client.Alias()
+	for name, definition := range aliases {
+		add := map[string]interface{}{"index": to, "alias": name}
+		if fields, ok := definition.(map[string]interface{}); ok {
+			for key, value := range fields {
+				add[key] = value
+			}
+		}
+		service = service.Action(
+			&rawAliasAction{action: "remove", body: map[string]interface{}{"index": from, "alias": name}},
+			&rawAliasAction{action: "add", body: add})
+	}
+	res, err := service.Do(ctx)
+	if err != nil {
+		return err
+	}
+	if !res.Acknowledged {
+		return errors.New("swap aliases not acknowledged")
+	}
+	return nil
+}
+
+// source target shards replicas pri.store.size node disk.avail aliases
+func printShrinkPlan(plan *shrinkPlan) {
+	var aliases []string
+	for name := range plan.aliases {
+		aliases = append(aliases, name)
+	}
+	sort.Strings(aliases)
+	if len(aliases) == 0 {
+		aliases = append(aliases, "-")
+	}
+
+	display := NewTableDisplay()
+	display.AddRow([]string{"source", "target", "shards", "replicas", "pri.store.size", "node", "disk.avail", "aliases", "delete_source"})
+	display.AddRow([]string{
+		plan.source,
+		plan.target,
+		fmt.Sprintf("%d -> %d", plan.pri, plan.shards),
+		strconv.Itoa(plan.rep),
+		formatBytes(plan.priSize),
+		plan.node,
+		formatBytes(plan.avail),
+		strings.Join(aliases, ","),
+		strconv.FormatBool(plan.deleteIt)})
+	display.Flush()
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	ctx "context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+// template list            [name*]
+var indicesTemplateListCommand = cli.Command{
+	Name:        "list",
+	Aliases:     []string{"l"},
+	Usage:       "List the index templates with the index patterns and order.",
+	ArgsUsage:   `[tpl* or tpl1,tpl2]`,
+	Description: `Display the index templates of elastic cluster, all the templates by default.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "set the format of output('text' (default), or 'json').",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return indicesTemplateListCmd(context)
+	},
+}
+
+// template put             name -f template.json|-
+var indicesTemplatePutCommand = cli.Command{
+	Name:      "put",
+	Usage:     "Create or replace an index template.",
+	ArgsUsage: `name -f template.json|- [--create]`,
+	Description: `Put the index template of file (or stdin with '-'), the template must have the index_patterns
+   (or template before 6.0). The existing template is replaced unless --create.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file, f",
+			Value: "",
+			Usage: "set the template file, '-' for stdin.",
+		},
+		cli.BoolFlag{
+			Name:  "create",
+			Usage: "only create the template, fail if the template exists.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 || context.String("file") == "" {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "put")
+			logrus.Fatalf("Must provide template name and --file for template put command!")
+		}
+
+		return indicesTemplatePutCmd(context)
+	},
+}
+
+// template delete          name
+var indicesTemplateDeleteCommand = cli.Command{
+	Name:        "delete",
+	Aliases:     []string{"rm"},
+	Usage:       "Delete an index template.",
+	ArgsUsage:   `name`,
+	Description: `Delete the index template, the existing indices are not changed.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "yes, y",
+			Usage: "Answer the template delete conform.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "delete")
+			logrus.Fatalf("Must provide template name for template delete command!")
+		}
+
+		return indicesTemplateDeleteCmd(context)
+	},
+}
+
+func indicesTemplateListCmd(context *cli.Context) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	service := client.IndexGetTemplate()
+	if names := context.Args().Get(0); names != "" {
+		service = service.Name(strings.Split(names, ",")...)
+	}
+	res, err := service.FlatSettings(true).Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	format := context.String("format")
+	switch format {
+	case "text":
+		printTemplatesList(res)
+	case "json":
+		jsonStr, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		fmt.Println(jsonPrettyPrint(string(jsonStr)))
+	default:
+		return fmt.Errorf("unknown format %q", context.String("format"))
+	}
+
+	return nil
+}
+
+// name index_patterns order version
+func printTemplatesList(templates map[string]*elastic.IndicesGetTemplateResponse) {
+	var names []string
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	display := NewTableDisplay()
+	display.AddRow([]string{"name", "index_patterns", "order", "version"})
+	for _, name := range names {
+		template := &indexTemplate{name: name, IndicesGetTemplateResponse: templates[name]}
+		version := "-"
+		if template.Version != 0 {
+			version = strconv.Itoa(template.Version)
+		}
+		display.AddRow([]string{name, strings.Join(template.patterns(), ","), strconv.Itoa(template.Order), version})
+	}
+	display.Flush()
+}
+
+func indicesTemplatePutCmd(context *cli.Context) error {
+	name := context.Args().Get(0)
+	data, err := readFileOrStdin(context.String("file"))
+	if err != nil {
+		return err
+	}
+	body, err := parseTemplateBody(data)
+	if err != nil {
+		return fmt.Errorf("invalid template of %s: %s", context.String("file"), err)
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	res, err := client.IndexPutTemplate(name).BodyJson(body).Create(context.Bool("create")).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if !res.Acknowledged {
+		return fmt.Errorf("put template %s not acknowledged", name)
+	}
+
+	fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] template %s updated.", name)))
+	return nil
+}
+
+// parseTemplateBody parse the template body, which must have the index_patterns or template.
+func parseTemplateBody(data []byte) (map[string]interface{}, error) {
+	var body map[string]interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	if _, ok := body["index_patterns"]; !ok {
+		if _, ok := body["template"]; !ok {
+			return nil, fmt.Errorf("index_patterns is missing")
+		}
+	}
+	return body, nil
+}
+
+func indicesTemplateDeleteCmd(context *cli.Context) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	name := context.Args().Get(0)
+	res, err := client.IndexGetTemplate(name).Do(ctx)
+	if err != nil {
+		return err
+	}
+	printTemplatesList(res)
+
+	fmt.Println(sgrBoldBlue("[Attention] Delete above templates? type (yes) to conform delete."))
+	if !context.Bool("yes") {
+		YesOrDie(fmt.Sprintf("delete template %s", name))
+	}
+
+	for templateName := range res {
+		if _, err := client.IndexDeleteTemplate(templateName).Do(ctx); err != nil {
+			return err
+		}
+		fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] template %s deleted.", templateName)))
+	}
+	return nil
+}
+
+// updateTemplatesSettings update the settings of templates, the other parts of the template
+// body (index_patterns, order, version, mappings and aliases) are put back as they are.
+func updateTemplatesSettings(client *elastic.Client, ctx ctx.Context, names []string, settings map[string]interface{}) error {
+	res, err := client.IndexGetTemplate(names...).FlatSettings(true).Do(ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, ok := res[name]; !ok && !strings.Contains(name, "*") {
+			return fmt.Errorf("template %s not found", name)
+		}
+	}
+	if len(res) == 0 {
+		return fmt.Errorf("template %s not found", strings.Join(names, ","))
+	}
+
+	// the names may be wildcards, update the templates matched.
+	var matched []string
+	for name := range res {
+		matched = append(matched, name)
+	}
+	sort.Strings(matched)
+
+	for _, name := range matched {
+		template := res[name]
+		if template.Settings == nil {
+			template.Settings = make(map[string]interface{})
+		}
+		for key, value := range settings {
+			old, ok := template.Settings[key]
+			if !ok {
+				old = "-"
+			}
+			fmt.Printf("template %s: %s %v -> %v\n", name, key, old, value)
+			template.Settings[key] = value
+		}
+
+		putRes, err := client.IndexPutTemplate(name).BodyJson(template).Do(ctx)
+		if err != nil {
+			return err
+		}
+		if !putRes.Acknowledged {
+			return fmt.Errorf("put template %s not acknowledged", name)
+		}
+		fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] template %s updated.", name)))
+	}
+	return nil
+}
+
+// template export          dir
+var indicesTemplateExportCommand = cli.Command{
+	Name:      "export",
+	Usage:     "Export the index templates to a directory.",
+	ArgsUsage: `dir [--name tpl*] [--all]`,
+	Description: `Write the index templates to the directory, one name.json file per template. The templates
+   start with '.' (ex: .monitoring-es) are managed by elasticsearch and skipped without --all.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "name",
+			Value: "",
+			Usage: "only export the templates of name, ex: logs*,metrics.",
+		},
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "export the templates start with '.' as well.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "export")
+			logrus.Fatalf("Must provide dir for template export command!")
+		}
+
+		return indicesTemplateExportCmd(context)
+	},
+}
+
+// template sync            dir
+var indicesTemplateSyncCommand = cli.Command{
+	Name:      "sync",
+	Usage:     "Sync the index templates of a directory to the cluster.",
+	ArgsUsage: `dir [--dry-run] [--all]`,
+	Description: `Compare the name.json files of the directory with the index templates of cluster, print the
+   unified diff of the templates to add, change and delete, and apply them after conform. The templates
+   start with '.' are never deleted without --all.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "delete the templates start with '.' not in the directory as well.",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only print the diff, the templates are not changed.",
+		},
+		cli.BoolFlag{
+			Name:  "yes, y",
+			Usage: "Answer the templates sync conform.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "sync")
+			logrus.Fatalf("Must provide dir for template sync command!")
+		}
+
+		return indicesTemplateSyncCmd(context)
+	},
+}
+
+// templateFileExt is the extension of the template files.
+const templateFileExt = ".json"
+
+// templateJSON format the template the same way for the files and the diff.
+func templateJSON(template *elastic.IndicesGetTemplateResponse) ([]byte, error) {
+	data, err := json.MarshalIndent(template, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func indicesTemplateExportCmd(context *cli.Context) error {
+	dir := context.Args().Get(0)
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	service := client.IndexGetTemplate()
+	if names := context.String("name"); names != "" {
+		service = service.Name(strings.Split(names, ",")...)
+	}
+	res, err := service.FlatSettings(true).Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	count := 0
+	for name, template := range res {
+		if strings.HasPrefix(name, ".") && !context.Bool("all") {
+			continue
+		}
+		data, err := templateJSON(template)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name+templateFileExt), data, 0644); err != nil {
+			return err
+		}
+		count++
+	}
+
+	fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] %d templates exported to %s.", count, dir)))
+	return nil
+}
+
+// readTemplateFile read the template of file, the settings are flattened and formatted as strings
+// the same as the template got with flat settings.
+func readTemplateFile(file string) (*elastic.IndicesGetTemplateResponse, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	body, err := parseTemplateBody(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template of %s: %s", file, err)
+	}
+	for key := range body {
+		switch key {
+		case "order", "version", "template", "index_patterns", "settings", "mappings", "aliases":
+		default:
+			return nil, fmt.Errorf("invalid template of %s: unknown key %q", file, key)
+		}
+	}
+
+	template := new(elastic.IndicesGetTemplateResponse)
+	if err := json.Unmarshal(data, template); err != nil {
+		return nil, fmt.Errorf("invalid template of %s: %s", file, err)
+	}
+	settings := flattenIndexSettings(template.Settings)
+	for key, value := range settings {
+		switch v := value.(type) {
+		case float64:
+			settings[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			settings[key] = strconv.FormatBool(v)
+		}
+	}
+	template.Settings = settings
+	return template, nil
+}
+
+// templateChange is the change of template from the cluster to the directory.
+type templateChange struct {
+	name     string
+	action   string
+	template *elastic.IndicesGetTemplateResponse
+}
+
+func indicesTemplateSyncCmd(context *cli.Context) error {
+	dir := context.Args().Get(0)
+	files, err := filepath.Glob(filepath.Join(dir, "*"+templateFileExt))
+	if err != nil {
+		return err
+	}
+	local := make(map[string]*elastic.IndicesGetTemplateResponse)
+	for _, file := range files {
+		template, err := readTemplateFile(file)
+		if err != nil {
+			return err
+		}
+		local[strings.TrimSuffix(filepath.Base(file), templateFileExt)] = template
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	remote, err := client.IndexGetTemplate().FlatSettings(true).Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]bool)
+	for name := range local {
+		names[name] = true
+	}
+	for name := range remote {
+		if !strings.HasPrefix(name, ".") || context.Bool("all") {
+			names[name] = true
+		}
+	}
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var changes []*templateChange
+	for _, name := range sorted {
+		var before, after []byte
+		if template, ok := remote[name]; ok {
+			if before, err = templateJSON(template); err != nil {
+				return err
+			}
+		}
+		if template, ok := local[name]; ok {
+			if after, err = templateJSON(template); err != nil {
+				return err
+			}
+		}
+
+		change := &templateChange{name: name, template: local[name]}
+		switch {
+		case before == nil:
+			change.action = "add"
+		case after == nil:
+			change.action = "delete"
+		case string(before) != string(after):
+			change.action = "change"
+		default:
+			continue
+		}
+		changes = append(changes, change)
+
+		beforeName, afterName := "cluster/"+name, filepath.Join(dir, name+templateFileExt)
+		if before == nil {
+			beforeName = "/dev/null"
+		}
+		if after == nil {
+			afterName = "/dev/null"
+		}
+		fmt.Printf("%s template %s:\n", change.action, name)
+		fmt.Println(unifiedDiff(beforeName, afterName, splitLines(before), splitLines(after)))
+	}
+
+	if len(changes) == 0 {
+		fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] templates of cluster are in sync with %s.", dir)))
+		return nil
+	}
+	if context.Bool("dry-run") {
+		return nil
+	}
+
+	fmt.Println(sgrBoldBlue("[Attention] Sync above templates? type (yes) to conform sync."))
+	if !context.Bool("yes") {
+		YesOrDie(fmt.Sprintf("sync %d templates from %s", len(changes), dir))
+	}
+
+	for _, change := range changes {
+		if change.action == "delete" {
+			if _, err := client.IndexDeleteTemplate(change.name).Do(ctx); err != nil {
+				return err
+			}
+		} else {
+			res, err := client.IndexPutTemplate(change.name).BodyJson(change.template).Do(ctx)
+			if err != nil {
+				return err
+			}
+			if !res.Acknowledged {
+				return fmt.Errorf("put template %s not acknowledged", change.name)
+			}
+		}
+		fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] %s template %s.", change.action, change.name)))
+	}
+	return nil
+}
+
+// splitLines split the text to lines without the last empty line.
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
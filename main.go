@@ -72,9 +72,14 @@ var runtimeCommandNotFound = commandNotFound
 // runtimeCommands is all sub-command
 var runtimeCommands = []cli.Command{
 	clusterCommand,
+	dataCommand,
 	indicesCommand,
 	nodesCommand,
+	pipelineCommand,
+	searchCommand,
+	snapshotCommand,
 	tasksCommand,
+	topCommand,
 }
 
 func beforeSubcommands(context *cli.Context) error {
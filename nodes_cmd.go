@@ -1,14 +1,13 @@
 package main
 
 import (
-	"bytes"
 	ctx "context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"reflect"
+	"strconv"
 	"strings"
-	"text/template"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
@@ -30,6 +29,8 @@ var nodesCommand = cli.Command{
 		nodesExcludeCommand,
 		// nodes include
 		nodesIncludeCommand,
+		// nodes drain
+		nodesDrainCommand,
 		// nodes info
 		nodesInfoCommand,
 	},
@@ -262,33 +263,220 @@ func printNodeAllocList(catAllocResp *elastic.CatAllocResponse) error {
 
 /////////////////////
 const (
-	excludeStr  = "cluster.routing.allocation.exclude._ip"
-	settingTemp = `{
-			"persistent": {
-				"cluster.routing.rebalance.enable": "all",
-				"cluster.routing.allocation.exclude._ip": "{{.}}" }
-			}`
+	excludePrefix = "cluster.routing.allocation.exclude."
+	rebalanceStr  = "cluster.routing.rebalance.enable"
 )
 
-// get "cluster.routing.allocation.exclude._ip" from cluster settings.
-func getSrcIPFromCluster(client *elastic.Client, ctx ctx.Context) (ipArray []string, err error) {
-	clusterGetSetting := client.ClusterGetSettings()
-	res, err := clusterGetSetting.FlatSettings(true).Do(ctx)
-	if err != nil {
-		return nil, err
+// excludeFlags is the common flags of exclude and include command.
+var excludeFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "by, b",
+		Value: "ip",
+		Usage: "select hosts by('ip' (default), 'name', 'host' or 'attr:<key>').",
+	},
+	cli.BoolFlag{
+		Name:  "transient",
+		Usage: "write the transient settings instead of persistent settings (which also update the transient value overriding them).",
+	},
+	cli.StringFlag{
+		Name:  "rebalance",
+		Value: "",
+		Usage: "also set cluster.routing.rebalance.enable('all', 'primaries', 'replicas' or 'none'), untouched by default.",
+	},
+}
+
+// getExcludeKey get the exclude setting key for --by: ip, name, host or attr:<key>.
+func getExcludeKey(by string) (string, error) {
+	switch by {
+	case "ip", "name", "host":
+		return excludePrefix + "_" + by, nil
+	}
+
+	if strings.HasPrefix(by, "attr:") {
+		attr := strings.TrimSpace(strings.TrimPrefix(by, "attr:"))
+		if attr == "" || strings.ContainsAny(attr, "\" \t") {
+			return "", fmt.Errorf("invalid attr name %q", attr)
+		}
+		return excludePrefix + attr, nil
+	}
+
+	return "", fmt.Errorf("unknown by %q, must be one of (ip, name, host, attr:<key>)", by)
+}
+
+// checkExcludeValues check the values of exclude setting key.
+func checkExcludeValues(key string, values []string) error {
+	if key == excludePrefix+"_ip" {
+		return checkIPAddr(values)
+	}
+
+	for _, value := range values {
+		if strings.ContainsAny(value, "\"\\") {
+			return fmt.Errorf("%s is invalid value for %s", value, key)
+		}
+	}
+
+	return nil
+}
+
+// checkRebalance check the value of cluster.routing.rebalance.enable.
+func checkRebalance(rebalance string) error {
+	switch rebalance {
+	case "", "all", "primaries", "replicas", "none":
+		return nil
+	}
+
+	return fmt.Errorf("invalid rebalance %q, must be one of (all, primaries, replicas, none)", rebalance)
+}
+
+// getSettingString get the string value of key from flat settings.
+func getSettingString(settings map[string]interface{}, key string) string {
+	if value, ok := settings[key]; ok && value != nil {
+		return strings.TrimSpace(fmt.Sprintf("%v", value))
 	}
 
-	resVal := reflect.ValueOf(*res)
-	persis := resVal.FieldByName("Persistent")
-	imap := persis.Interface()
-	a := imap.(map[string]interface{})
+	return ""
+}
 
-	if value, ok := a[excludeStr]; ok {
-		valueStr := strings.TrimSpace(value.(string))
-		ipArray = strings.Split(valueStr, ",")
+// get the effective values of exclude setting key (ex: "cluster.routing.allocation.exclude._ip"),
+// the transient settings override the persistent settings.
+func getExcludeFromCluster(res *elastic.ClusterGetSettingsResponse, key string) []string {
+	if valueArray := getExcludeFromSettings(res.Transient, key); len(valueArray) > 0 {
+		return valueArray
 	}
 
-	return ipArray, nil
+	return getExcludeFromSettings(res.Persistent, key)
+}
+
+// get the values of exclude setting key from the persistent or transient settings.
+func getExcludeFromSettings(settings map[string]interface{}, key string) (valueArray []string) {
+	if valueStr := getSettingString(settings, key); valueStr != "" {
+		valueArray = strings.Split(valueStr, ",")
+	}
+
+	return DeDuplicate(valueArray)
+}
+
+// updateExcludeToCluster update the values of exclude setting key with fn, and write it to the persistent
+// (or transient) cluster settings. The current values are read from both scopes, as the transient values
+// override the persistent values, writing persistent also updates the transient values if it holds the key,
+// and writing transient starts with the persistent values if it doesn't hold the key.
+// An error is returned if the effective values after updating are not the values computed.
+// The settings of cluster before and after updating are returned.
+func updateExcludeToCluster(client *elastic.Client, ctx ctx.Context, key string, transient bool, rebalance string,
+	fn func(srcArray []string) []string) (before, after *elastic.ClusterGetSettingsResponse, err error) {
+	if before, err = client.ClusterGetSettings().FlatSettings(true).Do(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	persistentSetting := map[string]interface{}{}
+	transientSetting := map[string]interface{}{}
+	var valueArray []string
+	if transient {
+		valueArray = DeDuplicate(fn(getExcludeFromCluster(before, key)))
+		persistentArray := getExcludeFromSettings(before.Persistent, key)
+		if len(valueArray) == 0 && len(persistentArray) > 0 {
+			return nil, nil, fmt.Errorf("transient %s would be empty and the persistent %q takes effect, update it without --transient",
+				key, strings.Join(persistentArray, ","))
+		}
+		transientSetting[key] = strings.Join(valueArray, ",")
+	} else {
+		valueArray = DeDuplicate(fn(getExcludeFromSettings(before.Persistent, key)))
+		persistentSetting[key] = strings.Join(valueArray, ",")
+
+		if transientArray := getExcludeFromSettings(before.Transient, key); len(transientArray) > 0 {
+			transientArray = DeDuplicate(fn(transientArray))
+			if len(transientArray) > 0 {
+				valueArray = transientArray
+				transientSetting[key] = strings.Join(transientArray, ",")
+			} else {
+				// reset the transient value, the persistent value takes effect.
+				transientSetting[key] = nil
+			}
+			logrus.Warnf("transient %s overrides the persistent settings, it's updated too", key)
+		}
+	}
+	if rebalance != "" {
+		if transient {
+			transientSetting[rebalanceStr] = rebalance
+		} else {
+			persistentSetting[rebalanceStr] = rebalance
+		}
+	}
+
+	body := map[string]interface{}{}
+	if len(persistentSetting) > 0 {
+		body["persistent"] = persistentSetting
+	}
+	if len(transientSetting) > 0 {
+		body["transient"] = transientSetting
+	}
+	clusterPutSetting := client.ClusterPutSettings()
+	if _, err = clusterPutSetting.FlatSettings(true).BodyJson(body).Do(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	if after, err = client.ClusterGetSettings().FlatSettings(true).Do(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	if effective := getExcludeFromCluster(after, key); !sameStringSet(effective, valueArray) {
+		return before, after, fmt.Errorf("the effective %s is %q, not %q", key, strings.Join(effective, ","),
+			strings.Join(valueArray, ","))
+	}
+
+	return before, after, nil
+}
+
+// sameStringSet is true if a and b contain the same strings.
+func sameStringSet(a, b []string) bool {
+	set := map[string]struct{}{}
+	for _, value := range a {
+		set[value] = struct{}{}
+	}
+	for _, value := range b {
+		if _, ok := set[value]; !ok {
+			return false
+		}
+	}
+	return len(set) == len(DeDuplicate(b))
+}
+
+// scope key before after changed
+func printSettingsDiff(keys []string, before, after *elastic.ClusterGetSettingsResponse) error {
+	if before == nil || after == nil {
+		return nil
+	}
+
+	display := NewTableDisplay()
+	display.AddRow([]string{"scope", "key", "before", "after", "changed"})
+	for _, scope := range []string{"persistent", "transient"} {
+		beforeSettings, afterSettings := before.Persistent, after.Persistent
+		if scope == "transient" {
+			beforeSettings, afterSettings = before.Transient, after.Transient
+		}
+
+		for _, key := range keys {
+			beforeValue := getSettingString(beforeSettings, key)
+			afterValue := getSettingString(afterSettings, key)
+			if beforeValue == "" && afterValue == "" {
+				continue
+			}
+
+			changed := ""
+			if beforeValue != afterValue {
+				changed = "*"
+			}
+			display.AddRow([]string{scope, key, beforeValue, afterValue, changed})
+		}
+	}
+	display.Flush()
+
+	for _, key := range keys {
+		if value := getSettingString(after.Transient, key); value != "" && getSettingString(after.Persistent, key) != "" {
+			fmt.Printf("\neffective %s: %q (transient overrides persistent)\n", key, value)
+		}
+	}
+	return nil
 }
 
 // node exclude
@@ -296,13 +484,14 @@ var nodesExcludeCommand = cli.Command{
 	Name:        "exclude",
 	Aliases:     []string{"e"},
 	Usage:       "Exclude hosts from elastic cluster.",
-	ArgsUsage:   `ip1,ip2`,
-	Description: `exclude hosts from elastic cluster.`,
+	ArgsUsage:   `ip1,ip2 (or name1,name2 with --by name)`,
+	Description: `exclude hosts from elastic cluster by ip, node name, host name or custom node attribute.`,
+	Flags:       excludeFlags,
 	Action: func(context *cli.Context) error {
 		if context.NArg() != 1 {
 			fmt.Printf("Incorrect Usage.\n\n")
 			cli.ShowCommandHelp(context, "exclude")
-			logrus.Fatalf("Must provide ip addrs for exclude command. (Example: ip1,ip2)")
+			logrus.Fatalf("Must provide values for exclude command. (Example: ip1,ip2)")
 		}
 
 		return nodesExcludeCmd(context)
@@ -310,16 +499,24 @@ var nodesExcludeCommand = cli.Command{
 }
 
 func nodesExcludeCmd(context *cli.Context) error {
-	var ipList string
-	var settingStr string
-	var buffer bytes.Buffer
+	var valueList string
+
+	if valueList = context.Args().Get(0); valueList == "" {
+		return errors.New("please check values for exclude command")
+	}
+
+	key, err := getExcludeKey(context.String("by"))
+	if err != nil {
+		return err
+	}
 
-	if ipList = context.Args().Get(0); ipList == "" {
-		return errors.New("please check ip addr for exclude command")
+	valueArray := strings.Split(valueList, ",")
+	if err := checkExcludeValues(key, valueArray); err != nil {
+		return err
 	}
 
-	ipArray := strings.Split(ipList, ",")
-	if err := checkIPAddr(ipArray); err != nil {
+	rebalance := context.String("rebalance")
+	if err := checkRebalance(rebalance); err != nil {
 		return err
 	}
 
@@ -333,65 +530,127 @@ func nodesExcludeCmd(context *cli.Context) error {
 	// Starting with elastic.v5, you must pass a context to execute each service
 	ctx := ctx.Background()
 
-	srcIPArray, err := getSrcIPFromCluster(client, ctx)
+	before, after, err := excludeFromCluster(client, ctx, key, valueArray, context.Bool("transient"), rebalance)
+	printSettingsDiff([]string{key, rebalanceStr}, before, after)
+	return err
+}
+
+// excludeFromCluster merge valueArray into the exclude setting key of cluster settings.
+func excludeFromCluster(client *elastic.Client, ctx ctx.Context, key string, valueArray []string, transient bool,
+	rebalance string) (before, after *elastic.ClusterGetSettingsResponse, err error) {
+	return updateExcludeToCluster(client, ctx, key, transient, rebalance, func(srcArray []string) []string {
+		return append(valueArray, srcArray...)
+	})
+}
+
+// node include
+var nodesIncludeCommand = cli.Command{
+	Name:        "include",
+	Aliases:     []string{"i"},
+	Usage:       "Add hosts to elastic cluster.",
+	ArgsUsage:   `ip1,ip2 (or name1,name2 with --by name)`,
+	Description: `add hosts to elastic cluster by ip, node name, host name or custom node attribute.`,
+	Flags:       excludeFlags,
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "include")
+			logrus.Fatalf("Must provide values for include command. (Example: ip1,ip2)")
+		}
+
+		return nodesIncludeCmd(context)
+	},
+}
+
+func nodesIncludeCmd(context *cli.Context) error {
+	var valueList string
+
+	if valueList = context.Args().Get(0); valueList == "" {
+		return errors.New("please check values for include command")
+	}
+
+	key, err := getExcludeKey(context.String("by"))
 	if err != nil {
 		return err
 	}
 
-	ipArray = append(ipArray, srcIPArray...)
-	ipArray = DeDuplicate(ipArray)
-	ipStr := strings.TrimRight(strings.Join(ipArray, ","), ",")
-	t := template.Must(template.New("settingTemp").Parse(settingTemp))
-	if err := t.Execute(&buffer, &ipStr); err != nil {
+	rmArray := strings.Split(valueList, ",")
+	if err := checkExcludeValues(key, rmArray); err != nil {
 		return err
 	}
 
-	settingStr = buffer.String()
-	clusterPutSetting := client.ClusterPutSettings()
-	ret, err := clusterPutSetting.FlatSettings(true).BodyJson(settingStr).Do(ctx)
-	if err != nil {
+	rebalance := context.String("rebalance")
+	if err := checkRebalance(rebalance); err != nil {
 		return err
 	}
 
-	jsonStr, err := json.Marshal(ret)
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
 	if err != nil {
 		return err
 	}
-	fmt.Println(jsonPrettyPrint(string(jsonStr)))
+	defer client.Stop()
 
-	return nil
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	rmSet := map[string]struct{}{}
+	for _, value := range rmArray {
+		rmSet[strings.TrimSpace(value)] = struct{}{}
+	}
+
+	before, after, err := updateExcludeToCluster(client, ctx, key, context.Bool("transient"), rebalance,
+		func(srcArray []string) []string {
+			var valueArray []string
+			for _, elem := range srcArray {
+				if _, ok := rmSet[elem]; !ok {
+					valueArray = append(valueArray, elem)
+				}
+			}
+			return valueArray
+		})
+	printSettingsDiff([]string{key, rebalanceStr}, before, after)
+	return err
 }
 
-// node include
-var nodesIncludeCommand = cli.Command{
-	Name:        "include",
-	Aliases:     []string{"i"},
-	Usage:       "Add hosts to elastic cluster.",
-	ArgsUsage:   `ip1,ip2`,
-	Description: `add hosts to elastic cluster.`,
+// node drain
+var nodesDrainCommand = cli.Command{
+	Name:        "drain",
+	Aliases:     []string{"d"},
+	Usage:       "Exclude a host and wait until all shards moved away from it.",
+	ArgsUsage:   `ip`,
+	Description: `exclude a host from elastic cluster, then wait until the node holds no shard and it is safe to stop.`,
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "timeout, t",
+			Value: 2 * time.Hour,
+			Usage: "set the max time to wait for the node drained.",
+		},
+		cli.DurationFlag{
+			Name:  "interval, i",
+			Value: 10 * time.Second,
+			Usage: "set the interval to refresh the relocating progress.",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if context.NArg() != 1 {
 			fmt.Printf("Incorrect Usage.\n\n")
-			cli.ShowCommandHelp(context, "include")
-			logrus.Fatalf("Must provide ip addrs for include command. (Example: ip1,ip2)")
+			cli.ShowCommandHelp(context, "drain")
+			logrus.Fatalf("Must provide ip addr for drain command. (Example: ip1)")
 		}
 
-		return nodesIncludeCmd(context)
+		return nodesDrainCmd(context)
 	},
 }
 
-func nodesIncludeCmd(context *cli.Context) error {
-	var ipList string
-	var settingStr string
-	var buffer bytes.Buffer
-	var srcIPArray []string
+func nodesDrainCmd(context *cli.Context) error {
+	var ip string
 
-	if ipList = context.Args().Get(0); ipList == "" {
-		return errors.New("please check ip addr for include command")
+	if ip = strings.TrimSpace(context.Args().Get(0)); ip == "" {
+		return errors.New("please check ip addr for drain command")
 	}
 
-	rmArray := strings.Split(ipList, ",")
-	if err := checkIPAddr(rmArray); err != nil {
+	if err := checkIPAddr([]string{ip}); err != nil {
 		return err
 	}
 
@@ -405,38 +664,116 @@ func nodesIncludeCmd(context *cli.Context) error {
 	// Starting with elastic.v5, you must pass a context to execute each service
 	ctx := ctx.Background()
 
-	if srcIPArray, err = getSrcIPFromCluster(client, ctx); err != nil {
+	if _, _, err := getNodeDrainProgress(client, ctx, ip); err != nil {
 		return err
 	}
 
-	for _, ip := range rmArray {
-		for i, elem := range srcIPArray {
-			if ip == elem {
-				srcIPArray = append(srcIPArray[:i], srcIPArray[i+1:]...)
-			}
+	key := excludePrefix + "_ip"
+	_, after, err := excludeFromCluster(client, ctx, key, []string{ip}, false, "")
+	if err != nil {
+		return err
+	}
+	// the transient value overrides the persistent value, the node may be still allowed,
+	// check it before the progress clears the screen.
+	excluded := false
+	for _, value := range getExcludeFromCluster(after, key) {
+		if value == ip {
+			excluded = true
 		}
 	}
-
-	srcIPArray = DeDuplicate(srcIPArray)
-	ipStr := strings.TrimRight(strings.Join(srcIPArray, ","), ",")
-	t := template.Must(template.New("settingTemp").Parse(settingTemp))
-	if err := t.Execute(&buffer, &ipStr); err != nil {
-		return err
+	if !excluded {
+		return fmt.Errorf("%s is not excluded, the transient %s is %q", ip, key, getSettingString(after.Transient, key))
 	}
 
-	settingStr = buffer.String()
+	timeout := context.Duration("timeout")
+	start := time.Now()
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(context.Duration("interval"))
+	defer ticker.Stop()
 
-	clusterPutSetting := client.ClusterPutSettings()
-	ret, err := clusterPutSetting.FlatSettings(true).BodyJson(settingStr).Do(ctx)
+	for {
+		alloc, shards, err := getNodeDrainProgress(client, ctx, ip)
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(CLEAR)
+		printDrainProgress(ip, alloc, shards, time.Since(start))
+		if len(shards.Shards) == 0 {
+			fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] %s holds no shard, it is safe to stop the node.", ip)))
+			return nil
+		}
+
+		select {
+		case <-deadline:
+			return fmt.Errorf("drain %s timeout after %s, %d shards remain, it is NOT safe to stop the node", ip, timeout, len(shards.Shards))
+		case <-ticker.C:
+		}
+	}
+}
+
+// getNodeDrainProgress get the allocation and the shards of the node with ip.
+func getNodeDrainProgress(client *elastic.Client, ctx ctx.Context, ip string) (*elastic.CatAllocResponse, *elastic.CatShardsResponse, error) {
+	allocRes, err := client.CatAllocService().Do(ctx)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	jsonRes, err := json.Marshal(ret)
+
+	alloc := &elastic.CatAllocResponse{Allocs: allocRes.Allocs[:0:0]}
+	for _, allocInfo := range allocRes.Allocs {
+		if allocInfo.Ip == ip {
+			alloc.Allocs = append(alloc.Allocs, allocInfo)
+		}
+	}
+	if len(alloc.Allocs) == 0 {
+		return nil, nil, fmt.Errorf("%s is not a data node of elastic cluster", ip)
+	}
+
+	shardsRes, err := client.CatShardsService().Bytes("b").Do(ctx)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	shards := &elastic.CatShardsResponse{Shards: shardsRes.Shards[:0:0]}
+	for _, shard := range shardsRes.Shards {
+		if shard.Ip == ip {
+			shards.Shards = append(shards.Shards, shard)
+		}
+	}
+
+	return alloc, shards, nil
+}
+
+// index shard prirep state store node
+func printDrainProgress(ip string, alloc *elastic.CatAllocResponse, shards *elastic.CatShardsResponse, elapsed time.Duration) error {
+	var relocating int
+	var remaining int64
+
+	display := NewTableDisplay()
+	display.AddRow([]string{"index", "shard", "prirep", "state", "store", "node"})
+	for _, shard := range shards.Shards {
+		size, _ := strconv.ParseInt(shard.Store, 10, 64)
+		remaining += size
+		if shard.State != "RELOCATING" {
+			continue
+		}
+		relocating++
+		display.AddRow([]string{
+			shard.Index,
+			shard.Shard,
+			shard.Prirep,
+			shard.State,
+			formatBytes(size),
+			shard.Node})
 	}
-	fmt.Println(jsonPrettyPrint(string(jsonRes)))
 
+	for _, allocInfo := range alloc.Allocs {
+		fmt.Printf("[Drain] %s (%s)  elapsed: %s\n", allocInfo.Node, ip, elapsed.Truncate(time.Second))
+		fmt.Printf("shards: %d  relocating: %d  remaining: %s  disk.used: %s\n\n",
+			len(shards.Shards), relocating, formatBytes(remaining), allocInfo.Used)
+	}
+
+	display.Flush()
 	return nil
 }
 
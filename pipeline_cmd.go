@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+var pipelineCommand = cli.Command{
+	Name:    "pipeline",
+	Aliases: []string{"pipe"},
+	Usage:   "Elastic ingest pipeline operation cmd.",
+	Subcommands: []cli.Command{
+		// pipeline list
+		pipelineListCommand,
+		// pipeline get
+		pipelineGetCommand,
+		// pipeline put
+		pipelinePutCommand,
+		// pipeline delete
+		pipelineDeleteCommand,
+		// pipeline simulate
+		pipelineSimulateCommand,
+	},
+}
+
+// list                   [id*]
+var pipelineListCommand = cli.Command{
+	Name:        "list",
+	Aliases:     []string{"l"},
+	Usage:       "List the ingest pipelines.",
+	ArgsUsage:   `[id* or id1,id2]`,
+	Description: `Display the ingest pipelines with the description, version and number of processors.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "set the format of output('text' (default), or 'json').",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return pipelineListCmd(context)
+	},
+}
+
+// get                    id
+var pipelineGetCommand = cli.Command{
+	Name:        "get",
+	Usage:       "Get the definition of ingest pipelines.",
+	ArgsUsage:   `id or id1,id2`,
+	Description: `Display the definition of ingest pipelines in json.`,
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "get")
+			logrus.Fatalf("Must provide pipeline id for pipeline get command!")
+		}
+
+		return pipelineGetCmd(context)
+	},
+}
+
+// put                    id -f pipeline.json|-
+var pipelinePutCommand = cli.Command{
+	Name:        "put",
+	Usage:       "Create or update an ingest pipeline.",
+	ArgsUsage:   `id -f pipeline.json|-`,
+	Description: `Put the pipeline of file (or stdin with '-'), ex: '{"description":"x","processors":[{"set":{"field":"a","value":1}}]}'.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file, f",
+			Value: "",
+			Usage: "set the pipeline file, '-' for stdin.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 || context.String("file") == "" {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "put")
+			logrus.Fatalf("Must provide pipeline id and --file for pipeline put command!")
+		}
+
+		return pipelinePutCmd(context)
+	},
+}
+
+// delete                 id
+var pipelineDeleteCommand = cli.Command{
+	Name:        "delete",
+	Aliases:     []string{"rm"},
+	Usage:       "Delete an ingest pipeline.",
+	ArgsUsage:   `id`,
+	Description: `Delete the ingest pipeline, the index requests with the pipeline fail after it's deleted.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "yes, y",
+			Usage: "Answer the pipeline delete conform.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "delete")
+			logrus.Fatalf("Must provide pipeline id for pipeline delete command!")
+		}
+
+		return pipelineDeleteCmd(context)
+	},
+}
+
+// simulate               [id] [-f pipeline.json] --docs docs.ndjson|-
+var pipelineSimulateCommand = cli.Command{
+	Name:      "simulate",
+	Aliases:   []string{"sim"},
+	Usage:     "Run the ingest pipeline against the sample documents.",
+	ArgsUsage: `[id] [-f pipeline.json] --docs docs.ndjson|- [--verbose]`,
+	Description: `Simulate the existing pipeline of id, or the pipeline of file before it's put, against the
+   documents of the ndjson file (or stdin with '-'), one document source per line. Print the output
+   or the error of each document, and the result of each processor with --verbose.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file, f",
+			Value: "",
+			Usage: "set the pipeline file to simulate, '-' for stdin.",
+		},
+		cli.StringFlag{
+			Name:  "docs, d",
+			Value: "",
+			Usage: "set the ndjson file of documents, '-' for stdin.",
+		},
+		cli.BoolFlag{
+			Name:  "verbose, v",
+			Usage: "print the result of each processor.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() > 1 || context.String("docs") == "" || (context.NArg() == 1) == (context.String("file") != "") {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "simulate")
+			logrus.Fatalf("Must provide pipeline id or --file, and --docs for pipeline simulate command!")
+		}
+		if context.String("file") == "-" && context.String("docs") == "-" {
+			return errors.New("pipeline simulate must not read both --file and --docs from stdin")
+		}
+
+		return pipelineSimulateCmd(context)
+	},
+}
+
+// pipelineBody is the definition of pipeline.
+type pipelineBody struct {
+	Description string                   `json:"description,omitempty"`
+	Version     int                      `json:"version,omitempty"`
+	Processors  []map[string]interface{} `json:"processors"`
+	OnFailure   []map[string]interface{} `json:"on_failure,omitempty"`
+}
+
+// getPipelines get the pipelines of ids, all the pipelines without ids.
+func getPipelines(client *elastic.Client, ctx ctx.Context, ids []string) (map[string]*pipelineBody, error) {
+	res, err := client.IngestGetPipeline(ids...).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pipelines := make(map[string]*pipelineBody)
+	for id, pipeline := range res {
+		pipelines[id] = &pipelineBody{
+			Description: pipeline.Description,
+			Version:     pipeline.Version,
+			Processors:  pipeline.Processors,
+			OnFailure:   pipeline.OnFailure,
+		}
+	}
+	return pipelines, nil
+}
+
+func pipelineListCmd(context *cli.Context) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	var ids []string
+	if context.Args().Get(0) != "" {
+		ids = strings.Split(context.Args().Get(0), ",")
+	}
+	pipelines, err := getPipelines(client, ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	format := context.String("format")
+	switch format {
+	case "text":
+		printPipelinesList(pipelines)
+	case "json":
+		jsonStr, err := json.Marshal(pipelines)
+		if err != nil {
+			return err
+		}
+		fmt.Println(jsonPrettyPrint(string(jsonStr)))
+	default:
+		return fmt.Errorf("unknown format %q", context.String("format"))
+	}
+
+	return nil
+}
+
+// id description version processors
+func printPipelinesList(pipelines map[string]*pipelineBody) {
+	var ids []string
+	for id := range pipelines {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	display := NewTableDisplay()
+	display.AddRow([]string{"id", "description", "version", "processors"})
+	for _, id := range ids {
+		pipeline := pipelines[id]
+		version := "-"
+		if pipeline.Version != 0 {
+			version = strconv.Itoa(pipeline.Version)
+		}
+		display.AddRow([]string{id, pipeline.Description, version, strconv.Itoa(len(pipeline.Processors))})
+	}
+	display.Flush()
+}
+
+func pipelineGetCmd(context *cli.Context) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	pipelines, err := getPipelines(client, ctx, strings.Split(context.Args().Get(0), ","))
+	if err != nil {
+		return err
+	}
+
+	jsonStr, err := json.Marshal(pipelines)
+	if err != nil {
+		return err
+	}
+	fmt.Println(jsonPrettyPrint(string(jsonStr)))
+	return nil
+}
+
+// readPipelineFile read the pipeline of file, which must have the processors.
+func readPipelineFile(file string) (map[string]interface{}, error) {
+	data, err := readFileOrStdin(file)
+	if err != nil {
+		return nil, err
+	}
+	var pipeline map[string]interface{}
+	if err := json.Unmarshal(data, &pipeline); err != nil {
+		return nil, fmt.Errorf("invalid pipeline of %s: %s", file, err)
+	}
+	if processors, ok := pipeline["processors"].([]interface{}); !ok || len(processors) == 0 {
+		return nil, fmt.Errorf("invalid pipeline of %s: processors is missing", file)
+	}
+	return pipeline, nil
+}
+
+func pipelinePutCmd(context *cli.Context) error {
+	pipeline, err := readPipelineFile(context.String("file"))
+	if err != nil {
+		return err
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	id := context.Args().Get(0)
+	res, err := client.IngestPutPipeline(id).BodyJson(pipeline).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if !res.Acknowledged {
+		return fmt.Errorf("put pipeline %s not acknowledged", id)
+	}
+
+	fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] pipeline %s updated.", id)))
+	return nil
+}
+
+func pipelineDeleteCmd(context *cli.Context) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	id := context.Args().Get(0)
+	pipelines, err := getPipelines(client, ctx, []string{id})
+	if err != nil {
+		return err
+	}
+	printPipelinesList(pipelines)
+
+	fmt.Println(sgrBoldBlue("[Attention] Delete above pipeline? type (yes) to conform delete."))
+	if !context.Bool("yes") {
+		YesOrDie(fmt.Sprintf("delete pipeline %s", id))
+	}
+
+	if _, err := client.IngestDeletePipeline(id).Do(ctx); err != nil {
+		return err
+	}
+
+	fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] pipeline %s deleted.", id)))
+	return nil
+}
+
+// readSimulateDocs read the documents of ndjson, the line is the source of document, or the
+// document with _index, _id and _source.
+func readSimulateDocs(file string) ([]map[string]interface{}, error) {
+	data, err := readFileOrStdin(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &doc); err != nil {
+			return nil, fmt.Errorf("invalid document of %s line %d: %s", file, line, err)
+		}
+		if _, ok := doc["_source"]; !ok {
+			doc = map[string]interface{}{"_source": doc}
+		}
+		docs = append(docs, doc)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("no document in %s", file)
+	}
+	return docs, nil
+}
+
+func pipelineSimulateCmd(context *cli.Context) error {
+	body := make(map[string]interface{})
+	if file := context.String("file"); file != "" {
+		pipeline, err := readPipelineFile(file)
+		if err != nil {
+			return err
+		}
+		body["pipeline"] = pipeline
+	}
+	docs, err := readSimulateDocs(context.String("docs"))
+	if err != nil {
+		return err
+	}
+	body["docs"] = docs
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	service := client.IngestSimulatePipeline().BodyJson(body)
+	if id := context.Args().Get(0); id != "" {
+		service = service.Id(id)
+	}
+	if context.Bool("verbose") {
+		service = service.Verbose(true)
+	}
+	res, err := service.Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for i, result := range res.Docs {
+		docFailed := result.Error != nil
+		for _, processor := range result.ProcessorResults {
+			if processor.Error != nil {
+				docFailed = true
+			}
+		}
+		if docFailed {
+			failed++
+		}
+		printSimulateResult(i+1, result)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d documents failed in the pipeline", failed, len(res.Docs))
+	}
+
+	fmt.Println(sgrBoldBlue(fmt.Sprintf("[OK] %d documents passed the pipeline.", len(res.Docs))))
+	return nil
+}
+
+// printSimulateResult print the output document or error, and the result of each processor with verbose.
+func printSimulateResult(n int, result *elastic.IngestSimulateDocumentResult) {
+	fmt.Printf("doc %d:\n", n)
+	if result.Error != nil {
+		fmt.Println(sgrBoldRed(fmt.Sprintf("[ERROR] %s: %s", result.Error.Type, result.Error.Reason)))
+	} else if result.Doc != nil {
+		printSimulateSource(result.Doc)
+	}
+
+	for i, processor := range result.ProcessorResults {
+		name := fmt.Sprintf("processor %d", i+1)
+		if processor.ProcessorTag != "" {
+			name += " [" + processor.ProcessorTag + "]"
+		}
+		if processor.Error != nil {
+			fmt.Printf("%s: %s\n", name, sgrBoldRed(fmt.Sprintf("[ERROR] %s: %s", processor.Error.Type, processor.Error.Reason)))
+			continue
+		}
+		fmt.Printf("%s:\n", name)
+		printSimulateSource(processor.Doc)
+	}
+}
+
+// printSimulateSource print the source of the simulated document, which is decoded from json.
+func printSimulateSource(doc map[string]interface{}) {
+	jsonStr, _ := json.Marshal(doc["_source"])
+	fmt.Println(jsonPrettyPrint(string(jsonStr)))
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+// search                 index [query]
+var searchCommand = cli.Command{
+	Name:      "search",
+	Aliases:   []string{"s"},
+	Usage:     "Search the documents of indices with the lucene query string.",
+	ArgsUsage: `index [query] [--size 10] [--sort field:desc] [--fields a,b] [--agg terms:host]`,
+	Description: `Search the documents matched the query string, ex: 'status:500 AND host:web*', all the documents
+   without query. Display the hits with the fields (the source without --fields), and the aggregations
+   of --agg type:field[:param], the next --agg is nested in the buckets of the previous one:
+     terms:field[:size], histogram:field:interval, date_histogram:field:interval,
+     avg|min|max|sum|cardinality:field (must be the last one).`,
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "size, n",
+			Value: 10,
+			Usage: "set the number of hits to display.",
+		},
+		cli.IntFlag{
+			Name:  "from",
+			Value: 0,
+			Usage: "set the offset of the first hit.",
+		},
+		cli.StringFlag{
+			Name:  "sort",
+			Value: "",
+			Usage: "sort the hits by the fields, ex: @timestamp:desc,host (asc by default), by score without it.",
+		},
+		cli.StringFlag{
+			Name:  "fields",
+			Value: "",
+			Usage: "only display the fields of source, ex: host,user.name.",
+		},
+		cli.StringSliceFlag{
+			Name:  "agg",
+			Value: &cli.StringSlice{},
+			Usage: "add an aggregation, ex: terms:host:5, can be repeated to nest the aggregations.",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "set the format of output('text' (default), or 'json').",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 || context.NArg() > 2 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "search")
+			logrus.Fatalf("Must provide index for search command!")
+		}
+
+		return searchCmd(context)
+	},
+}
+
+// searchAggSpec is the aggregation of --agg type:field[:param].
+type searchAggSpec struct {
+	kind  string
+	field string
+	param string
+}
+
+// isMetric is true if the aggregation is a single value metric without buckets.
+func (spec *searchAggSpec) isMetric() bool {
+	switch spec.kind {
+	case "avg", "min", "max", "sum", "cardinality":
+		return true
+	}
+	return false
+}
+
+func (spec *searchAggSpec) String() string {
+	return spec.kind + ":" + spec.field
+}
+
+// searchAggBucket is a bucket of terms or histogram aggregation.
+type searchAggBucket struct {
+	key      string
+	docCount int64
+	aggs     elastic.Aggregations
+}
+
+// parseSearchAggs parse the --agg, only the last one can be a metric.
+func parseSearchAggs(values []string) ([]*searchAggSpec, error) {
+	var specs []*searchAggSpec
+	for i, value := range values {
+		parts := strings.SplitN(value, ":", 3)
+		if len(parts) < 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid aggregation %q, must be type:field[:param]", value)
+		}
+		spec := &searchAggSpec{kind: parts[0], field: parts[1]}
+		if len(parts) == 3 {
+			spec.param = parts[2]
+		}
+
+		switch spec.kind {
+		case "terms":
+			if spec.param != "" {
+				if _, err := strconv.Atoi(spec.param); err != nil {
+					return nil, fmt.Errorf("invalid size of aggregation %q", value)
+				}
+			}
+		case "histogram":
+			if _, err := strconv.ParseFloat(spec.param, 64); err != nil {
+				return nil, fmt.Errorf("invalid interval of aggregation %q", value)
+			}
+		case "date_histogram":
+			if spec.param == "" {
+				return nil, fmt.Errorf("must provide interval of aggregation %q, ex: 1h", value)
+			}
+		default:
+			if !spec.isMetric() {
+				return nil, fmt.Errorf("unknown type of aggregation %q", value)
+			}
+			if i != len(values)-1 {
+				return nil, fmt.Errorf("metric aggregation %q must be the last one", value)
+			}
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// searchAggName is the name of aggregation in the request.
+func searchAggName(level int) string {
+	return "agg" + strconv.Itoa(level)
+}
+
+// buildSearchAgg build the aggregation of level, with the next levels as sub aggregation.
+func buildSearchAgg(specs []*searchAggSpec, level int) elastic.Aggregation {
+	spec := specs[level]
+	var sub elastic.Aggregation
+	if level+1 < len(specs) {
+		sub = buildSearchAgg(specs, level+1)
+	}
+	name := searchAggName(level + 1)
+
+	switch spec.kind {
+	case "terms":
+		agg := elastic.NewTermsAggregation().Field(spec.field)
+		if spec.param != "" {
+			size, _ := strconv.Atoi(spec.param)
+			agg = agg.Size(size)
+		}
+		if sub != nil {
+			agg = agg.SubAggregation(name, sub)
+		}
+		return agg
+	case "histogram":
+		interval, _ := strconv.ParseFloat(spec.param, 64)
+		agg := elastic.NewHistogramAggregation().Field(spec.field).Interval(interval)
+		if sub != nil {
+			agg = agg.SubAggregation(name, sub)
+		}
+		return agg
+	case "date_histogram":
+		agg := elastic.NewDateHistogramAggregation().Field(spec.field).Interval(spec.param)
+		if sub != nil {
+			agg = agg.SubAggregation(name, sub)
+		}
+		return agg
+	case "avg":
+		return elastic.NewAvgAggregation().Field(spec.field)
+	case "min":
+		return elastic.NewMinAggregation().Field(spec.field)
+	case "max":
+		return elastic.NewMaxAggregation().Field(spec.field)
+	case "sum":
+		return elastic.NewSumAggregation().Field(spec.field)
+	default:
+		return elastic.NewCardinalityAggregation().Field(spec.field)
+	}
+}
+
+// searchAggBuckets get the buckets of the terms or histogram aggregation.
+func searchAggBuckets(aggs elastic.Aggregations, spec *searchAggSpec, name string) []*searchAggBucket {
+	var buckets []*searchAggBucket
+	switch spec.kind {
+	case "terms":
+		items, found := aggs.Terms(name)
+		if !found {
+			return nil
+		}
+		for _, item := range items.Buckets {
+			key := fmt.Sprint(item.Key)
+			if item.KeyAsString != nil {
+				key = *item.KeyAsString
+			}
+			buckets = append(buckets, &searchAggBucket{key: key, docCount: item.DocCount, aggs: item.Aggregations})
+		}
+		if items.SumOfOtherDocCount > 0 {
+			buckets = append(buckets, &searchAggBucket{key: "(other)", docCount: items.SumOfOtherDocCount})
+		}
+	default:
+		items, found := aggs.Histogram(name)
+		if !found {
+			return nil
+		}
+		for _, item := range items.Buckets {
+			key := strconv.FormatFloat(item.Key, 'f', -1, 64)
+			if item.KeyAsString != nil {
+				key = *item.KeyAsString
+			}
+			buckets = append(buckets, &searchAggBucket{key: key, docCount: item.DocCount, aggs: item.Aggregations})
+		}
+	}
+	return buckets
+}
+
+// searchAggMetric get the value of the metric aggregation, empty if there is no value.
+func searchAggMetric(aggs elastic.Aggregations, name string) string {
+	// all the metric aggregations are parsed as the single value.
+	metric, found := aggs.Avg(name)
+	if !found || metric.Value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*metric.Value, 'f', -1, 64)
+}
+
+// printSearchAggs print the buckets of level as a table, the metric of the next level is
+// a column of the table, and the buckets of the next level are the nested tables.
+func printSearchAggs(aggs elastic.Aggregations, specs []*searchAggSpec, level int, indent string) {
+	spec := specs[level]
+	name := searchAggName(level)
+	if spec.isMetric() {
+		fmt.Printf("%s%s: %s\n", indent, spec, searchAggMetric(aggs, name))
+		return
+	}
+
+	var next *searchAggSpec
+	if level+1 < len(specs) {
+		next = specs[level+1]
+	}
+	buckets := searchAggBuckets(aggs, spec, name)
+
+	display := NewTableDisplay()
+	header := []string{indent + spec.String(), "doc_count"}
+	if next != nil && next.isMetric() {
+		header = append(header, next.String())
+	}
+	display.AddRow(header)
+	for _, bucket := range buckets {
+		row := []string{indent + bucket.key, strconv.FormatInt(bucket.docCount, 10)}
+		if next != nil && next.isMetric() {
+			row = append(row, searchAggMetric(bucket.aggs, searchAggName(level+1)))
+		}
+		display.AddRow(row)
+	}
+	display.Flush()
+
+	if next == nil || next.isMetric() {
+		return
+	}
+	for _, bucket := range buckets {
+		if bucket.aggs == nil {
+			continue
+		}
+		fmt.Printf("\n%s%s=%s\n", indent+"  ", spec.field, bucket.key)
+		printSearchAggs(bucket.aggs, specs, level+1, indent+"  ")
+	}
+}
+
+// printSearchHits print the hits with the fields, or the source without fields.
+func printSearchHits(hits []*elastic.SearchHit, fields []string) error {
+	display := NewTableDisplay()
+	header := []string{"_index", "_id", "_score"}
+	if len(fields) > 0 {
+		header = append(header, fields...)
+	} else {
+		header = append(header, "_source")
+	}
+	display.AddRow(header)
+
+	for _, hit := range hits {
+		score := "-"
+		if hit.Score != nil {
+			score = strconv.FormatFloat(*hit.Score, 'f', 3, 64)
+		}
+		row := []string{hit.Index, hit.Id, score}
+
+		source := json.RawMessage("{}")
+		if hit.Source != nil {
+			source = *hit.Source
+		}
+		if len(fields) > 0 {
+			var doc map[string]interface{}
+			if err := json.Unmarshal(source, &doc); err != nil {
+				return err
+			}
+			for _, field := range fields {
+				row = append(row, csvValue(fieldValue(doc, field)))
+			}
+		} else {
+			line, err := compactJSON(source)
+			if err != nil {
+				return err
+			}
+			row = append(row, string(line))
+		}
+		display.AddRow(row)
+	}
+	return display.Flush()
+}
+
+func searchCmd(context *cli.Context) error {
+	specs, err := parseSearchAggs(context.StringSlice("agg"))
+	if err != nil {
+		return err
+	}
+	var fields []string
+	if context.String("fields") != "" {
+		fields = strings.Split(context.String("fields"), ",")
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	var query elastic.Query = elastic.NewMatchAllQuery()
+	if q := context.Args().Get(1); q != "" {
+		query = elastic.NewQueryStringQuery(q)
+	}
+	search := client.Search(strings.Split(context.Args().Get(0), ",")...).
+		Query(query).
+		From(context.Int("from")).
+		Size(context.Int("size"))
+	if len(fields) > 0 {
+		search = search.FetchSourceContext(elastic.NewFetchSourceContext(true).Include(fields...))
+	}
+	if context.String("sort") != "" {
+		for _, sort := range strings.Split(context.String("sort"), ",") {
+			parts := strings.SplitN(sort, ":", 2)
+			if len(parts) == 2 && parts[1] != "asc" && parts[1] != "desc" {
+				return fmt.Errorf("invalid sort %q, must be field[:asc|desc]", sort)
+			}
+			search = search.Sort(parts[0], len(parts) == 1 || parts[1] == "asc")
+		}
+	}
+	if len(specs) > 0 {
+		search = search.Aggregation(searchAggName(0), buildSearchAgg(specs, 0))
+	}
+
+	res, err := search.Do(ctx)
+	if err != nil {
+		return err
+	}
+	if res.Hits == nil {
+		return errors.New("search response without hits")
+	}
+
+	switch context.String("format") {
+	case "json":
+		result := map[string]interface{}{
+			"took":  res.TookInMillis,
+			"total": res.Hits.TotalHits,
+			"hits":  res.Hits.Hits,
+		}
+		if len(res.Aggregations) > 0 {
+			result["aggregations"] = res.Aggregations
+		}
+		jsonStr, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		fmt.Println(jsonPrettyPrint(string(jsonStr)))
+	case "text":
+		fmt.Printf("hits: %d of %d, took: %dms\n\n", len(res.Hits.Hits), res.Hits.TotalHits, res.TookInMillis)
+		if len(res.Hits.Hits) > 0 {
+			if err := printSearchHits(res.Hits.Hits, fields); err != nil {
+				return err
+			}
+		}
+		if len(specs) > 0 {
+			fmt.Println()
+			printSearchAggs(res.Aggregations, specs, 0, "")
+		}
+	default:
+		return fmt.Errorf("unknown format %q", context.String("format"))
+	}
+	return nil
+}
@@ -7,6 +7,9 @@ const (
 
 	BOLD  = "\x1b[1m"
 	RESET = "\x1b[0m"
+
+	// move cursor to home and clear screen, for refresh display
+	CLEAR = "\x1b[H\x1b[2J"
 )
 
 func sgrBoldRed(text string) string {
@@ -0,0 +1,768 @@
+package main
+
+import (
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+var snapshotCommand = cli.Command{
+	Name:    "snapshot",
+	Aliases: []string{"snap"},
+	Usage:   "Elastic snapshot and restore operation cmd.",
+	Subcommands: []cli.Command{
+		// snapshot repo
+		snapshotRepoCommand,
+		// snapshot create
+		snapshotCreateCommand,
+		// snapshot list
+		snapshotListCommand,
+		// snapshot status
+		snapshotStatusCommand,
+		// snapshot delete
+		snapshotDeleteCommand,
+		// snapshot restore
+		snapshotRestoreCommand,
+	},
+}
+
+// repo
+var snapshotRepoCommand = cli.Command{
+	Name:    "repo",
+	Aliases: []string{"r"},
+	Usage:   "Snapshot repository operation cmd.",
+	Subcommands: []cli.Command{
+		// snapshot repo create
+		snapshotRepoCreateCommand,
+		// snapshot repo list
+		snapshotRepoListCommand,
+		// snapshot repo verify
+		snapshotRepoVerifyCommand,
+		// snapshot repo delete
+		snapshotRepoDeleteCommand,
+	},
+}
+
+// repo create            repoName
+var snapshotRepoCreateCommand = cli.Command{
+	Name:        "create",
+	Usage:       "Create or update a snapshot repository.",
+	ArgsUsage:   `repoName`,
+	Description: `The command create or update a snapshot repository, ex: -t fs -s '{"location": "/mount/backups"}'.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "type, t",
+			Value: "fs",
+			Usage: "set the type of repository('fs' (default), 'url', 's3', 'hdfs' ...).",
+		},
+		cli.StringFlag{
+			Name:  "settings, s",
+			Value: "",
+			Usage: "set the settings of repository: -s '{settings_json}'.",
+		},
+		cli.BoolFlag{
+			Name:  "no-verify",
+			Usage: "do not verify the repository on all nodes after creation.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "create")
+			logrus.Fatalf("Must provide repoName for repo create command!")
+		}
+
+		return snapshotRepoCreateCmd(context)
+	},
+}
+
+func snapshotRepoCreateCmd(context *cli.Context) error {
+	var repoName string
+	if repoName = context.Args().Get(0); repoName == "" {
+		return errors.New("please check repoName for repo create command")
+	}
+
+	settings := map[string]interface{}{}
+	if str := strings.TrimSpace(context.String("settings")); str != "" {
+		if err := json.Unmarshal([]byte(str), &settings); err != nil {
+			return fmt.Errorf("'%s' is not a json string", str)
+		}
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	res, err := client.SnapshotCreateRepository(repoName).
+		Type(context.String("type")).
+		Settings(settings).
+		Verify(!context.Bool("no-verify")).
+		Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	jsonStr, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	fmt.Println(jsonPrettyPrint(string(jsonStr)))
+
+	return nil
+}
+
+// repo list            [repo1,repo2]
+var snapshotRepoListCommand = cli.Command{
+	Name:        "list",
+	Aliases:     []string{"l"},
+	Usage:       "Display the snapshot repositories of elastic cluster.",
+	ArgsUsage:   `[repo1,repo2]`,
+	Description: `get snapshot repositories from elastic cluster.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "set the format of output('text' (default), or 'json').",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return snapshotRepoListCmd(context)
+	},
+}
+
+func snapshotRepoListCmd(context *cli.Context) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	repoService := client.SnapshotGetRepository()
+	if repos := context.Args().Get(0); repos != "" {
+		repoService.Repository(strings.Split(repos, ",")...)
+	}
+
+	res, err := repoService.Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	format := context.String("format")
+	switch format {
+	case "text":
+		printSnapshotRepoList(res)
+	case "json":
+		jsonStr, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		fmt.Println(jsonPrettyPrint(string(jsonStr)))
+	default:
+		return fmt.Errorf("unknown format %q", context.String("format"))
+	}
+
+	return nil
+}
+
+// repository type settings
+func printSnapshotRepoList(repoResp elastic.SnapshotGetRepositoryResponse) error {
+	if repoResp == nil {
+		return nil
+	}
+
+	var names []string
+	for name := range repoResp {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	display := NewTableDisplay()
+	display.AddRow([]string{"repository", "type", "settings"})
+	for _, name := range names {
+		settings, _ := json.Marshal(repoResp[name].Settings)
+		display.AddRow([]string{name, repoResp[name].Type, string(settings)})
+	}
+
+	display.Flush()
+	return nil
+}
+
+// repo verify            repoName
+var snapshotRepoVerifyCommand = cli.Command{
+	Name:        "verify",
+	Usage:       "Verify a snapshot repository on all nodes.",
+	ArgsUsage:   `repoName`,
+	Description: `The command verify a snapshot repository on all nodes of elastic cluster.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "set the format of output('text' (default), or 'json').",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "verify")
+			logrus.Fatalf("Must provide repoName for repo verify command!")
+		}
+
+		return snapshotRepoVerifyCmd(context)
+	},
+}
+
+func snapshotRepoVerifyCmd(context *cli.Context) error {
+	var repoName string
+	if repoName = context.Args().Get(0); repoName == "" {
+		return errors.New("please check repoName for repo verify command")
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	res, err := client.SnapshotVerifyRepository(repoName).Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	format := context.String("format")
+	switch format {
+	case "text":
+		printSnapshotRepoVerifyList(res)
+	case "json":
+		jsonStr, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		fmt.Println(jsonPrettyPrint(string(jsonStr)))
+	default:
+		return fmt.Errorf("unknown format %q", context.String("format"))
+	}
+
+	return nil
+}
+
+// id name
+func printSnapshotRepoVerifyList(verifyResp *elastic.SnapshotVerifyRepositoryResponse) error {
+	if verifyResp == nil {
+		return nil
+	}
+
+	var ids []string
+	for id := range verifyResp.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	display := NewTableDisplay()
+	display.AddRow([]string{"id", "name"})
+	for _, id := range ids {
+		display.AddRow([]string{id, verifyResp.Nodes[id].Name})
+	}
+
+	display.Flush()
+	return nil
+}
+
+// repo delete            repoName
+var snapshotRepoDeleteCommand = cli.Command{
+	Name:        "delete",
+	Aliases:     []string{"del"},
+	Usage:       "Delete a snapshot repository.",
+	ArgsUsage:   `repo1,repo2`,
+	Description: `The command unregister the snapshot repositories, the snapshots in it are kept.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "yes, y",
+			Usage: "Answer delete repository conform.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "delete")
+			logrus.Fatalf("Must provide repoName for repo delete command!")
+		}
+
+		return snapshotRepoDeleteCmd(context)
+	},
+}
+
+func snapshotRepoDeleteCmd(context *cli.Context) error {
+	var repoName string
+	if repoName = context.Args().Get(0); repoName == "" {
+		return errors.New("please check repoName for repo delete command")
+	}
+
+	repoList := strings.Split(repoName, ",")
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+	fmt.Println(sgrBoldBlue("[Attention] Delete below repositories? type (yes) to conform delete."))
+	if !context.Bool("yes") {
+		YesOrDie(strings.Join(repoList, " "))
+	}
+
+	res, err := client.SnapshotDeleteRepository(repoList...).Do(ctx)
+	if err != nil {
+		return err
+	}
+	jsonStr, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	fmt.Println(jsonPrettyPrint(string(jsonStr)))
+
+	return nil
+}
+
+// create            repoName snapshotName
+var snapshotCreateCommand = cli.Command{
+	Name:        "create",
+	Usage:       "Create a snapshot in the repository.",
+	ArgsUsage:   `repoName snapshotName`,
+	Description: `The command create a snapshot of the indices (default all) in the repository.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "indices, i",
+			Value: "",
+			Usage: "set indices for snapshot (index1,logstash-*).",
+		},
+		cli.BoolFlag{
+			Name:  "wait, w",
+			Usage: "wait until the snapshot has completed.",
+		},
+		cli.BoolFlag{
+			Name:  "ignore-unavailable",
+			Usage: "ignore the indices which are not available.",
+		},
+		cli.BoolFlag{
+			Name:  "partial",
+			Usage: "allow snapshot of indices which have unavailable primary shards.",
+		},
+		cli.BoolFlag{
+			Name:  "no-global-state",
+			Usage: "do not store the cluster global state in the snapshot.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 2 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "create")
+			logrus.Fatalf("Must provide repoName and snapshotName for create command!")
+		}
+
+		return snapshotCreateCmd(context)
+	},
+}
+
+func snapshotCreateCmd(context *cli.Context) error {
+	repoName := context.Args().Get(0)
+	snapshotName := context.Args().Get(1)
+	if repoName == "" || snapshotName == "" {
+		return errors.New("please check repoName and snapshotName for create command")
+	}
+
+	body := map[string]interface{}{
+		"ignore_unavailable":   context.Bool("ignore-unavailable"),
+		"partial":              context.Bool("partial"),
+		"include_global_state": !context.Bool("no-global-state"),
+	}
+	if indices := context.String("indices"); indices != "" {
+		body["indices"] = strings.Join(DeDuplicate(strings.Split(indices, ",")), ",")
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	res, err := client.SnapshotCreate(repoName, snapshotName).
+		WaitForCompletion(context.Bool("wait")).
+		BodyJson(body).
+		Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	jsonStr, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	fmt.Println(jsonPrettyPrint(string(jsonStr)))
+
+	return nil
+}
+
+// list            repoName
+var snapshotListCommand = cli.Command{
+	Name:        "list",
+	Aliases:     []string{"l"},
+	Usage:       "Display the snapshots of a repository.",
+	ArgsUsage:   `repoName [snap1,snap2]`,
+	Description: `get snapshots list of a repository from elastic cluster.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "set the format of output('text' (default), or 'json').",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "list")
+			logrus.Fatalf("Must provide repoName for list command!")
+		}
+
+		return snapshotListCmd(context)
+	},
+}
+
+func snapshotListCmd(context *cli.Context) error {
+	var repoName string
+	if repoName = context.Args().Get(0); repoName == "" {
+		return errors.New("please check repoName for list command")
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	snapshotService := client.SnapshotGet(repoName)
+	if snapshots := context.Args().Get(1); snapshots != "" {
+		snapshotService.Snapshot(strings.Split(snapshots, ",")...)
+	}
+
+	res, err := snapshotService.Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	format := context.String("format")
+	switch format {
+	case "text":
+		printSnapshotList(res)
+	case "json":
+		jsonStr, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		fmt.Println(jsonPrettyPrint(string(jsonStr)))
+	default:
+		return fmt.Errorf("unknown format %q", context.String("format"))
+	}
+
+	return nil
+}
+
+// snapshot state indices start end duration shards failed
+func printSnapshotList(snapshotResp *elastic.SnapshotGetResponse) error {
+	if snapshotResp == nil {
+		return nil
+	}
+
+	display := NewTableDisplay()
+	display.AddRow([]string{"snapshot", "state", "indices", "start", "end", "duration", "shards", "failed"})
+	for _, snapshot := range snapshotResp.Snapshots {
+		display.AddRow([]string{
+			snapshot.Snapshot,
+			snapshot.State,
+			strconv.Itoa(len(snapshot.Indices)),
+			snapshot.StartTime.Local().Format("2006-01-02 15:04:05"),
+			snapshot.EndTime.Local().Format("2006-01-02 15:04:05"),
+			(time.Duration(snapshot.DurationInMillis) * time.Millisecond).String(),
+			fmt.Sprintf("%d/%d", snapshot.Shards.Successful, snapshot.Shards.Total),
+			strconv.Itoa(snapshot.Shards.Failed)})
+	}
+
+	display.Flush()
+	return nil
+}
+
+// status            [repoName [snap1,snap2]]
+var snapshotStatusCommand = cli.Command{
+	Name:        "status",
+	Aliases:     []string{"st"},
+	Usage:       "Display the status of snapshots.",
+	ArgsUsage:   `[repoName [snap1,snap2]]`,
+	Description: `get the detailed status of snapshots, the running snapshots without arguments.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "set the format of output('text' (default), or 'json').",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return snapshotStatusCmd(context)
+	},
+}
+
+func snapshotStatusCmd(context *cli.Context) error {
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	statusService := client.SnapshotStatus()
+	if repoName := context.Args().Get(0); repoName != "" {
+		statusService.Repository(repoName)
+	}
+	if snapshots := context.Args().Get(1); snapshots != "" {
+		statusService.Snapshot(strings.Split(snapshots, ",")...)
+	}
+
+	res, err := statusService.Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	format := context.String("format")
+	switch format {
+	case "text":
+		printSnapshotStatusList(res)
+	case "json":
+		jsonStr, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		fmt.Println(jsonPrettyPrint(string(jsonStr)))
+	default:
+		return fmt.Errorf("unknown format %q", context.String("format"))
+	}
+
+	return nil
+}
+
+// snapshot repository state shards files size percent time
+func printSnapshotStatusList(statusResp *elastic.SnapshotStatusResponse) error {
+	if statusResp == nil {
+		return nil
+	}
+
+	display := NewTableDisplay()
+	display.AddRow([]string{"snapshot", "repository", "state", "shards", "failed", "files", "size", "percent", "time"})
+	for _, status := range statusResp.Snapshots {
+		percent := 100.0
+		if status.Stats.TotalSizeInBytes > 0 {
+			percent = float64(status.Stats.ProcessedSizeInBytes) * 100 / float64(status.Stats.TotalSizeInBytes)
+		}
+		display.AddRow([]string{
+			status.Snapshot,
+			status.Repository,
+			status.State,
+			fmt.Sprintf("%d/%d", status.ShardsStats.Done, status.ShardsStats.Total),
+			strconv.Itoa(status.ShardsStats.Failed),
+			fmt.Sprintf("%d/%d", status.Stats.ProcessedFiles, status.Stats.NumberOfFiles),
+			fmt.Sprintf("%s/%s", formatBytes(status.Stats.ProcessedSizeInBytes), formatBytes(status.Stats.TotalSizeInBytes)),
+			fmt.Sprintf("%.1f%%", percent),
+			(time.Duration(status.Stats.TimeInMillis) * time.Millisecond).String()})
+	}
+
+	display.Flush()
+	return nil
+}
+
+// delete            repoName snapshotName
+var snapshotDeleteCommand = cli.Command{
+	Name:        "delete",
+	Aliases:     []string{"del"},
+	Usage:       "Delete a snapshot from the repository.",
+	ArgsUsage:   `repoName snapshotName`,
+	Description: `The command delete a snapshot, or abort it if the snapshot is running.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "yes, y",
+			Usage: "Answer delete snapshot conform.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 2 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "delete")
+			logrus.Fatalf("Must provide repoName and snapshotName for delete command!")
+		}
+
+		return snapshotDeleteCmd(context)
+	},
+}
+
+func snapshotDeleteCmd(context *cli.Context) error {
+	repoName := context.Args().Get(0)
+	snapshotName := context.Args().Get(1)
+	if repoName == "" || snapshotName == "" {
+		return errors.New("please check repoName and snapshotName for delete command")
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+	fmt.Println(sgrBoldBlue("[Attention] Delete below snapshot? type (yes) to conform delete."))
+	if !context.Bool("yes") {
+		YesOrDie(fmt.Sprintf("%s/%s", repoName, snapshotName))
+	}
+
+	res, err := client.SnapshotDelete(repoName, snapshotName).Do(ctx)
+	if err != nil {
+		return err
+	}
+	jsonStr, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	fmt.Println(jsonPrettyPrint(string(jsonStr)))
+
+	return nil
+}
+
+// restore            repoName snapshotName
+var snapshotRestoreCommand = cli.Command{
+	Name:        "restore",
+	Usage:       "Restore a snapshot from the repository.",
+	ArgsUsage:   `repoName snapshotName`,
+	Description: `The command restore the indices (default all) of a snapshot, ex: --rename-pattern 'logs-(.+)' --rename-replacement 'restored-logs-$1'.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "indices, i",
+			Value: "",
+			Usage: "set indices for restore (index1,logstash-*).",
+		},
+		cli.StringFlag{
+			Name:  "rename-pattern",
+			Value: "",
+			Usage: "set the regular expression to rename the restored indices.",
+		},
+		cli.StringFlag{
+			Name:  "rename-replacement",
+			Value: "",
+			Usage: "set the replacement of rename pattern, use $1 for the matched group.",
+		},
+		cli.BoolFlag{
+			Name:  "partial",
+			Usage: "allow restore of indices which have unavailable shards in snapshot.",
+		},
+		cli.BoolFlag{
+			Name:  "include-global-state",
+			Usage: "restore the cluster global state (templates, persistent settings) too.",
+		},
+		cli.BoolFlag{
+			Name:  "ignore-unavailable",
+			Usage: "ignore the indices which are missing in snapshot.",
+		},
+		cli.BoolFlag{
+			Name:  "wait, w",
+			Usage: "wait until the restore has completed.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 2 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "restore")
+			logrus.Fatalf("Must provide repoName and snapshotName for restore command!")
+		}
+
+		return snapshotRestoreCmd(context)
+	},
+}
+
+func snapshotRestoreCmd(context *cli.Context) error {
+	repoName := context.Args().Get(0)
+	snapshotName := context.Args().Get(1)
+	if repoName == "" || snapshotName == "" {
+		return errors.New("please check repoName and snapshotName for restore command")
+	}
+
+	if (context.String("rename-pattern") == "") != (context.String("rename-replacement") == "") {
+		return errors.New("rename-pattern and rename-replacement must be provided together")
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	restoreService := client.SnapshotRestore(repoName, snapshotName).
+		RenamePattern(context.String("rename-pattern")).
+		RenameReplacement(context.String("rename-replacement")).
+		Partial(context.Bool("partial")).
+		IncludeGlobalState(context.Bool("include-global-state")).
+		IgnoreUnavailable(context.Bool("ignore-unavailable")).
+		WaitForCompletion(context.Bool("wait"))
+	if indices := context.String("indices"); indices != "" {
+		restoreService.Indices(DeDuplicate(strings.Split(indices, ","))...)
+	}
+
+	res, err := restoreService.Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	jsonStr, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	fmt.Println(jsonPrettyPrint(string(jsonStr)))
+
+	return nil
+}
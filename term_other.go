@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!dragonfly
+
+package main
+
+import (
+	"errors"
+)
+
+var errTermNotSupported = errors.New("terminal control is not supported on this platform")
+
+// makeCbreak is not supported, the keyboard navigation is disabled.
+func makeCbreak(fd int) (func() error, error) {
+	return nil, errTermNotSupported
+}
+
+// getTermSize is not supported.
+func getTermSize(fd int) (int, int, error) {
+	return 0, 0, errTermNotSupported
+}
@@ -0,0 +1,38 @@
+//go:build linux || darwin || freebsd || dragonfly
+// +build linux darwin freebsd dragonfly
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// makeCbreak put the terminal into cbreak mode: read the keys one by one without echo,
+// the signals (Ctrl-C) still work. It returns a func to restore the terminal.
+func makeCbreak(fd int) (func() error, error) {
+	old, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return nil, err
+	}
+
+	term := *old
+	term.Lflag &^= unix.ICANON | unix.ECHO
+	term.Cc[unix.VMIN] = 1
+	term.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlWriteTermios, &term); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return unix.IoctlSetTermios(fd, ioctlWriteTermios, old)
+	}, nil
+}
+
+// getTermSize get the width and height of the terminal.
+func getTermSize(fd int) (int, int, error) {
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"bytes"
+	ctx "context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/olivere/elastic"
+)
+
+const (
+	topMinInterval = time.Second
+	topMaxRecovery = 5
+	topHelp        = "q:quit  tab:switch table  left/right:sort column  r:reverse  up/down:scroll  +/-:interval"
+)
+
+// keys of the terminal, the arrow keys are escape sequences.
+const (
+	keyUp    = "\x1b[A"
+	keyDown  = "\x1b[B"
+	keyRight = "\x1b[C"
+	keyLeft  = "\x1b[D"
+	keyTab   = "\t"
+)
+
+// top
+var topCommand = cli.Command{
+	Name:  "top",
+	Usage: "Display a live dashboard of elastic cluster, nodes and indices.",
+	Description: `Refresh the cluster status, the heap/cpu/load and the indexing/search rate of nodes and indices,
+   and the active recoveries every interval. The rates are computed from the deltas of the stats.
+   Keys: q quit, tab switch the nodes/indices table, left/right change the sort column,
+   r reverse the sort order, up/down scroll the table, +/- change the interval.`,
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "interval, i",
+			Value: 5 * time.Second,
+			Usage: "set the interval to refresh the dashboard.",
+		},
+		cli.StringFlag{
+			Name:  "index",
+			Value: "_all",
+			Usage: "only display the indices match the pattern, ex: logstash-*.",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return topCmd(context)
+	},
+}
+
+// topColumn is a column of dashboard table, the numeric column is sorted by value.
+type topColumn struct {
+	title   string
+	numeric bool
+}
+
+type topRow struct {
+	cells  []string
+	values []float64
+}
+
+// add a cell to the row, the value is used to sort the numeric column.
+func (r *topRow) add(cell string, value float64) {
+	r.cells = append(r.cells, cell)
+	r.values = append(r.values, value)
+}
+
+// topTable is a sortable and scrollable table of the dashboard.
+type topTable struct {
+	title   string
+	columns []topColumn
+	rows    []*topRow
+	sortCol int
+	reverse bool
+	offset  int
+}
+
+func (t *topTable) Len() int      { return len(t.rows) }
+func (t *topTable) Swap(i, j int) { t.rows[i], t.rows[j] = t.rows[j], t.rows[i] }
+
+// Less sort the numeric column from the largest, and the others by alphabet.
+func (t *topTable) Less(i, j int) bool {
+	if t.columns[t.sortCol].numeric {
+		return t.rows[i].values[t.sortCol] > t.rows[j].values[t.sortCol]
+	}
+	return t.rows[i].cells[t.sortCol] < t.rows[j].cells[t.sortCol]
+}
+
+// setRows replace the rows and keep the sort order.
+func (t *topTable) setRows(rows []*topRow) {
+	t.rows = rows
+	t.sort()
+}
+
+func (t *topTable) sort() {
+	if t.reverse {
+		sort.Stable(sort.Reverse(t))
+	} else {
+		sort.Stable(t)
+	}
+}
+
+// render output the visible rows of table, the sort column is marked in header.
+func (t *topTable) render(w io.Writer, focused bool, lines int) {
+	mark := "  "
+	if focused {
+		mark = "> "
+	}
+
+	if t.offset > len(t.rows)-lines {
+		t.offset = len(t.rows) - lines
+	}
+	if t.offset < 0 {
+		t.offset = 0
+	}
+	start, end := t.offset, t.offset+lines
+	if end > len(t.rows) {
+		end = len(t.rows)
+	}
+	first := start + 1
+	if end == 0 {
+		first = 0
+	}
+	fmt.Fprintf(w, "%s%s [%d-%d of %d]\n", mark, t.title, first, end, len(t.rows))
+
+	display := NewTableDisplayWriter(w)
+	var header []string
+	for i, column := range t.columns {
+		title := column.title
+		if i == t.sortCol {
+			if t.reverse == column.numeric {
+				title += "^"
+			} else {
+				title += "v"
+			}
+		}
+		header = append(header, title)
+	}
+	display.AddRow(header)
+	for _, row := range t.rows[start:end] {
+		display.AddRow(row.cells)
+	}
+	display.Flush()
+}
+
+// topStats is the stats of cluster at a time.
+type topStats struct {
+	time       time.Time
+	health     *elastic.ClusterHealthResponse
+	nodes      *elastic.NodesStatsResponse
+	indices    *elastic.IndicesStatsResponse
+	recoveries *elastic.CatRecoveryResponse
+}
+
+// topDashboard keep the stats of last two refresh and the state of tables.
+type topDashboard struct {
+	interval time.Duration
+	prev     *topStats
+	curr     *topStats
+	err      error
+	tables   []*topTable
+	focus    int
+}
+
+func newTopDashboard(interval time.Duration) *topDashboard {
+	return &topDashboard{
+		interval: interval,
+		tables: []*topTable{
+			{
+				title: "nodes",
+				columns: []topColumn{
+					{"name", false}, {"ip", false}, {"role", false},
+					{"heap%", true}, {"cpu%", true}, {"load_1m", true}, {"disk.avail", true},
+					{"docs", true}, {"index/s", true}, {"search/s", true},
+				},
+				sortCol: 3,
+			},
+			{
+				title: "indices",
+				columns: []topColumn{
+					{"index", false}, {"docs", true}, {"store.size", true},
+					{"index/s", true}, {"search/s", true},
+				},
+				sortCol: 3,
+			},
+		},
+	}
+}
+
+func topCmd(context *cli.Context) error {
+	interval := context.Duration("interval")
+	if interval < topMinInterval {
+		interval = topMinInterval
+	}
+
+	// Create a client and connect to addr.
+	client, err := NewElasticClient(context)
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	// Starting with elastic.v5, you must pass a context to execute each service
+	ctx := ctx.Background()
+
+	// the keyboard navigation is disabled if stdin is not a terminal.
+	keys := make(chan string, 16)
+	restore, err := makeCbreak(int(os.Stdin.Fd()))
+	if err != nil {
+		logrus.Warnf("keyboard is disabled: %s", err)
+	} else {
+		defer restore()
+		go readKeys(os.Stdin, keys)
+	}
+
+	// keep the last screen and move the prompt to a new line.
+	defer fmt.Println()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	dashboard := newTopDashboard(interval)
+	refresh := time.After(0)
+	for {
+		select {
+		case <-refresh:
+			dashboard.update(getTopStats(client, ctx, context.String("index")))
+			refresh = time.After(dashboard.interval)
+		case key := <-keys:
+			if !dashboard.handleKey(key) {
+				return nil
+			}
+		case <-sigs:
+			return nil
+		}
+		dashboard.render()
+	}
+}
+
+// readKeys read the keys from terminal, an escape sequence is a key.
+func readKeys(r io.Reader, keys chan<- string) {
+	buf := make([]byte, 16)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		keys <- string(buf[:n])
+	}
+}
+
+// handleKey update the state of dashboard, returns false to quit.
+func (d *topDashboard) handleKey(key string) bool {
+	table := d.tables[d.focus]
+	switch key {
+	case "q", "Q", "":
+		return false
+	case keyTab:
+		d.focus = (d.focus + 1) % len(d.tables)
+	case keyLeft, "h":
+		table.sortCol = (table.sortCol + len(table.columns) - 1) % len(table.columns)
+		table.sort()
+	case keyRight, "l":
+		table.sortCol = (table.sortCol + 1) % len(table.columns)
+		table.sort()
+	case "r":
+		table.reverse = !table.reverse
+		table.sort()
+	case keyUp, "k":
+		table.offset--
+	case keyDown, "j":
+		table.offset++
+	case "+":
+		d.interval += time.Second
+	case "-":
+		if d.interval-time.Second >= topMinInterval {
+			d.interval -= time.Second
+		}
+	}
+	return true
+}
+
+// getTopStats get the stats of cluster health, nodes, indices and recoveries.
+func getTopStats(client *elastic.Client, ctx ctx.Context, index string) (*topStats, error) {
+	stats := &topStats{time: time.Now()}
+
+	var err error
+	stats.health, err = client.ClusterHealth().Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.nodes, err = client.NodesStats().Metric("jvm", "os", "fs", "indices").
+		IndexMetric("docs", "indexing", "search").Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.indices, err = client.IndexStats(index).Metric("docs", "store", "indexing", "search").Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.recoveries, err = client.CatRecoveryService().Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// update the dashboard with the stats, the last stats is kept if failed.
+func (d *topDashboard) update(stats *topStats, err error) {
+	d.err = err
+	if err != nil {
+		return
+	}
+	d.prev, d.curr = d.curr, stats
+
+	d.tables[0].setRows(d.nodeRows())
+	d.tables[1].setRows(d.indexRows())
+}
+
+// rate compute the per second rate of a counter from the last refresh, -1 is unknown.
+func (d *topDashboard) rate(curr, prev int64, ok bool) float64 {
+	if !ok || d.prev == nil || curr < prev {
+		return -1
+	}
+	seconds := d.curr.time.Sub(d.prev.time).Seconds()
+	if seconds <= 0 {
+		return -1
+	}
+	return float64(curr-prev) / seconds
+}
+
+func formatRate(rate float64) string {
+	if rate < 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(rate, 'f', 1, 64)
+}
+
+// name ip role heap% cpu% load_1m disk.avail docs index/s search/s
+func (d *topDashboard) nodeRows() []*topRow {
+	var ids []string
+	for id := range d.curr.nodes.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var rows []*topRow
+	for _, id := range ids {
+		node := d.curr.nodes.Nodes[id]
+		row := &topRow{}
+		row.add(node.Name, 0)
+		row.add(node.IP, 0)
+		row.add(strings.Join(node.Roles, ","), 0)
+
+		var heap, cpu, load, avail float64 = -1, -1, -1, -1
+		if node.JVM != nil && node.JVM.Mem != nil {
+			heap = float64(node.JVM.Mem.HeapUsedPercent)
+		}
+		if node.OS != nil && node.OS.CPU != nil {
+			cpu = float64(node.OS.CPU.Percent)
+			if value, ok := node.OS.CPU.LoadAverage["1m"]; ok {
+				load = value
+			}
+		}
+		if node.FS != nil && node.FS.Total != nil {
+			avail = float64(node.FS.Total.AvailableInBytes)
+		}
+		row.add(formatPercent(heap), heap)
+		row.add(formatPercent(cpu), cpu)
+		row.add(formatRate(load), load)
+		if avail < 0 {
+			row.add("-", avail)
+		} else {
+			row.add(formatBytes(int64(avail)), avail)
+		}
+
+		var docs, indexTotal, queryTotal int64
+		if node.Indices != nil {
+			if node.Indices.Docs != nil {
+				docs = node.Indices.Docs.Count
+			}
+			if node.Indices.Indexing != nil {
+				indexTotal = node.Indices.Indexing.IndexTotal
+			}
+			if node.Indices.Search != nil {
+				queryTotal = node.Indices.Search.QueryTotal
+			}
+		}
+		row.add(strconv.FormatInt(docs, 10), float64(docs))
+
+		var prevIndex, prevQuery int64
+		prev, ok := d.prevNode(id)
+		if ok {
+			prevIndex, prevQuery = prev.Indices.Indexing.IndexTotal, prev.Indices.Search.QueryTotal
+		}
+		indexRate := d.rate(indexTotal, prevIndex, ok)
+		queryRate := d.rate(queryTotal, prevQuery, ok)
+		row.add(formatRate(indexRate), indexRate)
+		row.add(formatRate(queryRate), queryRate)
+
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// prevNode get the node stats of last refresh, the node may be restarted.
+func (d *topDashboard) prevNode(id string) (*elastic.NodesStatsNode, bool) {
+	if d.prev == nil {
+		return nil, false
+	}
+	node, ok := d.prev.nodes.Nodes[id]
+	if !ok || node.Indices == nil || node.Indices.Indexing == nil || node.Indices.Search == nil {
+		return nil, false
+	}
+	return node, true
+}
+
+// index docs store.size index/s search/s
+// the index rate is count on primaries, and the search rate is count on all shards.
+func (d *topDashboard) indexRows() []*topRow {
+	var names []string
+	for name := range d.curr.indices.Indices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rows []*topRow
+	for _, name := range names {
+		indexTotal, queryTotal, docs, size, ok := indexStatsCounters(d.curr.indices.Indices[name])
+		if !ok {
+			continue
+		}
+
+		var prevIndex, prevQuery int64
+		var prevOK bool
+		if d.prev != nil {
+			prevIndex, prevQuery, _, _, prevOK = indexStatsCounters(d.prev.indices.Indices[name])
+		}
+		indexRate := d.rate(indexTotal, prevIndex, prevOK)
+		queryRate := d.rate(queryTotal, prevQuery, prevOK)
+
+		row := &topRow{}
+		row.add(name, 0)
+		row.add(strconv.FormatInt(docs, 10), float64(docs))
+		row.add(formatBytes(size), float64(size))
+		row.add(formatRate(indexRate), indexRate)
+		row.add(formatRate(queryRate), queryRate)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// indexStatsCounters get the index_total of primaries, query_total, docs and store size of an index.
+func indexStatsCounters(stats *elastic.IndexStats) (indexTotal, queryTotal, docs, size int64, ok bool) {
+	if stats == nil || stats.Primaries == nil || stats.Total == nil {
+		return 0, 0, 0, 0, false
+	}
+	if stats.Primaries.Indexing != nil {
+		indexTotal = stats.Primaries.Indexing.IndexTotal
+	}
+	if stats.Primaries.Docs != nil {
+		docs = stats.Primaries.Docs.Count
+	}
+	if stats.Total.Search != nil {
+		queryTotal = stats.Total.Search.QueryTotal
+	}
+	if stats.Total.Store != nil {
+		size = stats.Total.Store.SizeInBytes
+	}
+	return indexTotal, queryTotal, docs, size, true
+}
+
+func formatPercent(value float64) string {
+	if value < 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%%", value)
+}
+
+// render the dashboard in a buffer and output it at once to avoid flicker,
+// the lines are cut to the size of terminal.
+func (d *topDashboard) render() {
+	width, height, err := getTermSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 120, 40
+	}
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "elastic-trib top - %s, refresh every %s\n", time.Now().Format("15:04:05"), d.interval)
+	if d.curr == nil {
+		fmt.Fprintln(&buffer, "loading...")
+	} else {
+		health := d.curr.health
+		fmt.Fprintf(&buffer, "cluster: %s  status: %s  nodes: %d  data nodes: %d  pending tasks: %d\n",
+			health.ClusterName, health.Status, health.NumberOfNodes, health.NumberOfDataNodes, health.NumberOfPendingTasks)
+		fmt.Fprintf(&buffer, "shards: %d active, %d primary, %d relocating, %d initializing, %d unassigned (%.1f%%)\n",
+			health.ActiveShards, health.ActivePrimaryShards, health.RelocatingShards,
+			health.InitializingShards, health.UnassignedShards, health.ActiveShardsPercentAsNumber)
+	}
+	if d.err != nil {
+		fmt.Fprintf(&buffer, "refresh failed: %s\n", d.err)
+	} else {
+		fmt.Fprintln(&buffer, topHelp)
+	}
+
+	recoveries := d.activeRecoveries().Recoverys
+	shown := len(recoveries)
+	if shown > topMaxRecovery {
+		shown = topMaxRecovery
+	}
+
+	// the tables share the lines left by the headers, the recoveries and the table titles.
+	lines := height - 4 - (shown + 3) - 2*2 - 1
+	if lines < 2 {
+		lines = 2
+	}
+	nodeLines := lines / 2
+	if n := len(d.tables[0].rows); n < nodeLines {
+		nodeLines = n
+	}
+	fmt.Fprintln(&buffer)
+	d.tables[0].render(&buffer, d.focus == 0, nodeLines)
+	fmt.Fprintln(&buffer)
+	d.tables[1].render(&buffer, d.focus == 1, lines-nodeLines)
+
+	fmt.Fprintln(&buffer)
+	fmt.Fprintf(&buffer, "  active recoveries [%d]\n", len(recoveries))
+	if len(recoveries) > 0 {
+		display := NewTableDisplayWriter(&buffer)
+		display.AddRow([]string{"index", "shard", "type", "stage", "source", "target", "files%", "bytes%", "translog%", "time"})
+		for _, recovery := range recoveries[:shown] {
+			display.AddRow([]string{
+				recovery.Index,
+				recovery.Shard,
+				recovery.Type,
+				recovery.Stage,
+				recovery.SourceHost,
+				recovery.TargetHost,
+				recovery.FilesPercent,
+				recovery.BytesPercent,
+				recovery.TranslogPercent,
+				recovery.Time})
+		}
+		display.Flush()
+	}
+
+	out := strings.Split(strings.TrimRight(buffer.String(), "\n"), "\n")
+	if len(out) > height {
+		out = out[:height]
+	}
+	for i, line := range out {
+		if runes := []rune(line); len(runes) > width {
+			out[i] = string(runes[:width])
+		}
+	}
+	fmt.Print(CLEAR + strings.Join(out, "\n"))
+}
+
+// activeRecoveries get the recoveries not done.
+func (d *topDashboard) activeRecoveries() *elastic.CatRecoveryResponse {
+	res := &elastic.CatRecoveryResponse{}
+	if d.curr == nil {
+		return res
+	}
+
+	res.Recoverys = d.curr.recoveries.Recoverys[:0:0]
+	for _, recovery := range d.curr.recoveries.Recoverys {
+		if recovery.Stage != "done" {
+			res.Recoverys = append(res.Recoverys, recovery)
+		}
+	}
+	return res
+}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"os/exec"
@@ -12,6 +13,7 @@ import (
 	"strings"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
 )
 
 // fatal prints the error's details
@@ -61,6 +63,70 @@ func jsonPrettyPrint(in string) string {
 	return out.String()
 }
 
+// readFileOrStdin read the content of file, or stdin if the name is "-".
+func readFileOrStdin(name string) ([]byte, error) {
+	if name == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(name)
+}
+
+// parseTrailingFlags parse the flags after the args of the command with subcommands, and return the args.
+// The command with subcommands is run as a sub app, which stops parsing flags at the first arg,
+// ex: the -g of `indices template tpl1 -g`.
+func parseTrailingFlags(context *cli.Context, flags []cli.Flag) ([]string, error) {
+	var args []string
+	tail := context.Args()
+	for i := 0; i < len(tail); i++ {
+		arg := tail[i]
+		if arg == "--" {
+			args = append(args, tail[i+1:]...)
+			break
+		}
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			args = append(args, arg)
+			continue
+		}
+
+		name, value := strings.TrimLeft(arg, "-"), ""
+		hasValue := false
+		if index := strings.Index(name, "="); index >= 0 {
+			name, value, hasValue = name[:index], name[index+1:], true
+		}
+
+		var found cli.Flag
+		for _, flag := range flags {
+			for _, flagName := range strings.Split(flag.GetName(), ",") {
+				if strings.TrimSpace(flagName) == name {
+					found = flag
+				}
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("flag provided but not defined: %s", arg)
+		}
+
+		if _, ok := found.(cli.BoolFlag); ok {
+			if !hasValue {
+				value = "true"
+			}
+		} else if !hasValue {
+			if i+1 >= len(tail) {
+				return nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			value = tail[i]
+		}
+		for _, flagName := range strings.Split(found.GetName(), ",") {
+			if err := context.Set(strings.TrimSpace(flagName), value); err != nil {
+				return nil, fmt.Errorf("invalid value %q for flag %s: %s", value, arg, err)
+			}
+		}
+	}
+
+	return args, nil
+}
+
 func checkURLScheme(addr, scheme string) string {
 	var address string
 
@@ -89,6 +155,21 @@ func checkIPAddr(iparray []string) error {
 	return nil
 }
 
+// formatBytes make a human readable size string, ex: 1.5gb.
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%db", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cb", float64(size)/float64(div), "kmgtpe"[exp])
+}
+
 // GetCurrPath get current path string
 func GetCurrPath() string {
 	file, _ := exec.LookPath(os.Args[0])
@@ -109,3 +190,92 @@ func YesOrDie(msg string) {
 		logrus.Fatalf("*** Aborting...")
 	}
 }
+
+// unifiedDiff make the unified diff of the lines with 3 lines of context, empty if they are the same.
+func unifiedDiff(aName, bName string, a, b []string) string {
+	const context = 3
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// ai and bi are the line numbers of a and b before the line.
+	type diffLine struct {
+		op     byte
+		text   string
+		ai, bi int
+	}
+	var lines []diffLine
+	var changes []int
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			changes = append(changes, len(lines))
+			lines = append(lines, diffLine{'-', a[i], i, j})
+			i++
+		default:
+			changes = append(changes, len(lines))
+			lines = append(lines, diffLine{'+', b[j], i, j})
+			j++
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+	for k := 0; k < len(changes); {
+		// the changes closer than 2 contexts are in the same hunk.
+		last := k
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*context {
+			last++
+		}
+		start, end := changes[k]-context, changes[last]+context+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		aStart, bStart, aCount, bCount := lines[start].ai+1, lines[start].bi+1, 0, 0
+		for _, line := range lines[start:end] {
+			if line.op != '+' {
+				aCount++
+			}
+			if line.op != '-' {
+				bCount++
+			}
+		}
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, line := range lines[start:end] {
+			fmt.Fprintf(&out, "%c%s\n", line.op, line.text)
+		}
+		k = last + 1
+	}
+	return out.String()
+}